@@ -0,0 +1,38 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Broker carries state changes between replicas of the site so that every replica converges on the same boards.
+// Messages published to a topic are delivered to every subscriber of that topic, including those on the publishing replica.
+type Broker interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe delivers messages for topic until ctx is done, after which the channel is closed.
+	// A nil message means others may have been missed, as while a connection was lost, and the subscriber should
+	// ask its peers for their state again.
+	Subscribe(ctx context.Context, topic string) (<-chan []byte, error)
+	Close() error
+}
+
+// New creates a broker from a URL such as "redis://localhost:6379".
+// An empty URL or "memory://" creates an in-process broker, which is all a single replica needs.
+func New(rawURL string) (Broker, error) {
+	if rawURL == "" {
+		return NewMemory(), nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker url %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "memory":
+		return NewMemory(), nil
+	case "redis":
+		return NewRedis(u.Host), nil
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,129 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// Messages queued per subscriber before publishers have to wait for it.
+	memoryBuffer = 256
+	// Publishes without a deadline of their own give up after this long, so a stuck subscriber cannot stall the callers.
+	memoryPublishTimeout = 5 * time.Second
+)
+
+// Memory is an in-process broker. It keeps a single replica working through the same code path as a clustered one.
+// Nothing is ever dropped: a publish waits for every subscriber to take the message, or fails.
+type Memory struct {
+	// publishTimeout bounds each publish whose context has no deadline.
+	publishTimeout time.Duration
+
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+}
+
+// memoryTopic delivers one message at a time, so every subscriber sees the same order.
+type memoryTopic struct {
+	// turn holds a token while a message is being delivered.
+	turn chan struct{}
+	subs []*memorySubscription
+}
+
+type memorySubscription struct {
+	ctx context.Context
+	// mu is held for reading while sending on c, and for writing to close it.
+	mu     sync.RWMutex
+	c      chan []byte
+	closed bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		publishTimeout: memoryPublishTimeout,
+		topics:         make(map[string]*memoryTopic),
+	}
+}
+
+// Publish delivers payload to the topic's current subscribers, waiting for any whose buffer is full.
+// A topic without any is simply dropped. If ctx ends first, some subscribers may already have the message.
+func (m *Memory) Publish(ctx context.Context, topic string, payload []byte) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.publishTimeout)
+		defer cancel()
+	}
+	m.mu.Lock()
+	t, ok := m.topics[topic]
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	select {
+	case t.turn <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("memory publish: %w", ctx.Err())
+	}
+	defer func() { <-t.turn }()
+	m.mu.Lock()
+	subs := slices.Clone(t.subs)
+	m.mu.Unlock()
+	for _, sub := range subs {
+		if err := sub.send(ctx, payload); err != nil {
+			return fmt.Errorf("memory publish: %w", err)
+		}
+	}
+	return nil
+}
+
+// send waits for the subscriber to take payload. A subscriber that leaves meanwhile no longer needs it.
+func (s *memorySubscription) send(ctx context.Context, payload []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil
+	}
+	select {
+	case s.c <- payload:
+		return nil
+	case <-s.ctx.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Subscribe creates the topic on first use. It is removed again once its last subscriber goes, as topics are named
+// after rooms that come and go.
+func (m *Memory) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.topics[topic]
+	if !ok {
+		t = &memoryTopic{turn: make(chan struct{}, 1)}
+		m.topics[topic] = t
+	}
+	sub := &memorySubscription{ctx: ctx, c: make(chan []byte, memoryBuffer)}
+	t.subs = append(t.subs, sub)
+	context.AfterFunc(ctx, func() {
+		m.mu.Lock()
+		t.subs = slices.DeleteFunc(t.subs, func(s *memorySubscription) bool { return s == sub })
+		if m.topics[topic] == t && len(t.subs) == 0 {
+			delete(m.topics, topic)
+		}
+		m.mu.Unlock()
+		// Any send in progress sees ctx is done and lets go of the lock.
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		sub.closed = true
+		close(sub.c)
+	})
+	return sub.c, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
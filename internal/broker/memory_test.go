@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fill publishes messages until the subscriber's buffer is full.
+func fill(t *testing.T, m *Memory, topic string) {
+	t.Helper()
+	for i := range memoryBuffer {
+		if err := m.Publish(t.Context(), topic, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryPublishWaitsForASlowSubscriber(t *testing.T) {
+	m := NewMemory()
+	rx, err := m.Subscribe(t.Context(), "checks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill(t, m, "checks")
+
+	done := make(chan error, 1)
+	go func() { done <- m.Publish(t.Context(), "checks", []byte("last")) }()
+	select {
+	case err := <-done:
+		t.Fatalf("publish to a full subscriber returned %v straight away, want it to wait", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Nothing was dropped to make room: every message arrives, in order.
+	for i := range memoryBuffer {
+		if got := receive(t, rx); got != strconv.Itoa(i) {
+			t.Fatalf("message %v is %q", i, got)
+		}
+	}
+	if got := receive(t, rx); got != "last" {
+		t.Fatalf("last message is %q, want %q", got, "last")
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryPublishGivesUpOnAStuckSubscriber(t *testing.T) {
+	m := NewMemory()
+	m.publishTimeout = 50 * time.Millisecond
+	if _, err := m.Subscribe(t.Context(), "checks"); err != nil {
+		t.Fatal(err)
+	}
+	fill(t, m, "checks")
+
+	if err := m.Publish(context.Background(), "checks", []byte("late")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("publish without a deadline to a stuck subscriber returned %v, want a deadline error", err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := m.Publish(ctx, "checks", []byte("late")); !errors.Is(err, context.Canceled) {
+		t.Errorf("publish with a cancelled context returned %v, want it cancelled", err)
+	}
+}
+
+func TestMemorySubscriberLeavingReleasesPublishers(t *testing.T) {
+	m := NewMemory()
+	ctx, cancel := context.WithCancel(t.Context())
+	rx, err := m.Subscribe(ctx, "checks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill(t, m, "checks")
+
+	done := make(chan error, 1)
+	go func() { done <- m.Publish(t.Context(), "checks", []byte("unwanted")) }()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("publish to a subscriber that left returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish kept waiting for a subscriber that left")
+	}
+	for range rx {
+	}
+	// The topic went with its last subscriber.
+	if err := m.Publish(t.Context(), "checks", []byte("nobody")); err != nil {
+		t.Error(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.topics) != 0 {
+		t.Errorf("%v topics left after every subscriber went", len(m.topics))
+	}
+}
@@ -0,0 +1,286 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	redisDialTimeout = 5 * time.Second
+	// Publishes without a deadline of their own give up after this long, so a hung server cannot stall the callers.
+	redisPublishTimeout = 5 * time.Second
+	// Subscriptions that lose their connection retry with this backoff rather than hammering the server.
+	redisRetryDelay = time.Second
+)
+
+var ErrClosed = errors.New("broker closed")
+
+// Redis is a broker that speaks the Redis serialization protocol (RESP) using PUBLISH and SUBSCRIBE.
+// Any server implementing those two commands works, which keeps it testable against a local stand-in.
+type Redis struct {
+	addr string
+	// publishTimeout bounds each publish whose context has no deadline.
+	publishTimeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewRedis(addr string) *Redis {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Redis{
+		addr:           addr,
+		publishTimeout: redisPublishTimeout,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+func (rb *Redis) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: redisDialTimeout}
+	return dialer.DialContext(ctx, "tcp", rb.addr)
+}
+
+func (rb *Redis) Publish(ctx context.Context, topic string, payload []byte) error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	if rb.conn == nil {
+		conn, err := rb.dial(ctx)
+		if err != nil {
+			return fmt.Errorf("redis publish: %w", err)
+		}
+		rb.conn = conn
+		rb.r = bufio.NewReader(conn)
+	}
+
+	// The lock is held for the round trip, so every publish needs a deadline or one stuck reply blocks all of them.
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(rb.publishTimeout)
+	}
+	_ = rb.conn.SetDeadline(deadline)
+	// Cancelling ctx abandons the round trip straight away.
+	conn := rb.conn
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+	err := writeCommand(rb.conn, []byte("PUBLISH"), []byte(topic), payload)
+	if err == nil {
+		_, err = readReply(rb.r)
+	}
+	if err != nil {
+		// The connection state is unknown after a failure, so start afresh on the next publish.
+		_ = rb.conn.Close()
+		rb.conn = nil
+		rb.r = nil
+		return fmt.Errorf("redis publish: %w", err)
+	}
+	return nil
+}
+
+func (rb *Redis) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	if rb.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+	// Closing the broker ends every subscription as well.
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(rb.ctx, cancel)
+
+	conn, r, err := rb.subscribe(ctx, topic)
+	if err != nil {
+		stop()
+		cancel()
+		return nil, err
+	}
+
+	out := make(chan []byte, memoryBuffer)
+	go func() {
+		defer close(out)
+		defer stop()
+		defer cancel()
+		for {
+			rb.receive(ctx, conn, r, topic, out)
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("redis subscription lost, reconnecting", "topic", topic, "addr", rb.addr)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(redisRetryDelay):
+				}
+				conn, r, err = rb.subscribe(ctx, topic)
+				if err == nil {
+					break
+				}
+				slog.Error("redis resubscribe failed", "topic", topic, "error", err)
+			}
+			// Anything published while the connection was down is gone, so the subscriber has to resync.
+			select {
+			case out <- nil:
+			case <-ctx.Done():
+				_ = conn.Close()
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// subscribe opens a dedicated connection and waits for the server to confirm the subscription.
+func (rb *Redis) subscribe(ctx context.Context, topic string) (net.Conn, *bufio.Reader, error) {
+	conn, err := rb.dial(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("redis subscribe: %w", err)
+	}
+	r := bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(redisDialTimeout))
+	if err := writeCommand(conn, []byte("SUBSCRIBE"), []byte(topic)); err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("redis subscribe: %w", err)
+	}
+	reply, err := readReply(r)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("redis subscribe: %w", err)
+	}
+	if kind, _, _ := pushMessage(reply); kind != "subscribe" {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("redis subscribe: unexpected reply %v", reply)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, r, nil
+}
+
+// receive forwards messages from conn until the connection fails or ctx is done.
+func (rb *Redis) receive(ctx context.Context, conn net.Conn, r *bufio.Reader, topic string, out chan<- []byte) {
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("redis receive", "topic", topic, "error", err)
+			}
+			return
+		}
+		kind, channel, payload := pushMessage(reply)
+		if kind != "message" || channel != topic {
+			continue
+		}
+		select {
+		case out <- payload:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (rb *Redis) Close() error {
+	rb.cancel()
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.conn == nil {
+		return nil
+	}
+	err := rb.conn.Close()
+	rb.conn = nil
+	rb.r = nil
+	return err
+}
+
+// pushMessage unpacks a pub/sub reply of the form [kind, channel, payload].
+func pushMessage(reply any) (kind, channel string, payload []byte) {
+	parts, ok := reply.([]any)
+	if !ok || len(parts) != 3 {
+		return "", "", nil
+	}
+	k, _ := parts[0].([]byte)
+	c, _ := parts[1].([]byte)
+	p, _ := parts[2].([]byte)
+	return string(k), string(c), p
+}
+
+// writeCommand encodes args as a RESP array of bulk strings.
+func writeCommand(conn net.Conn, args ...[]byte) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n", len(arg))
+		buf.Write(arg)
+		buf.WriteString("\r\n")
+	}
+	_, err := conn.Write(buf.Bytes())
+	return err
+}
+
+// readReply decodes a single RESP value.
+// Simple strings and bulk strings are returned as []byte, integers as int64 and arrays as []any.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	body := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, fmt.Errorf("server error: %s", body)
+	case ':':
+		return strconv.ParseInt(string(body), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("malformed bulk length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("malformed array length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]any, n)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type %q", line[0])
+	}
+}
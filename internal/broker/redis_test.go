@@ -0,0 +1,229 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standIn is a minimal RESP server implementing just enough of PUBLISH and SUBSCRIBE for the Redis broker.
+type standIn struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	subs map[string][]net.Conn
+}
+
+func newStandIn(t *testing.T) *standIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &standIn{ln: ln, subs: make(map[string][]net.Conn)}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// drop closes every subscriber's connection, as a server restart would.
+func (s *standIn) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for topic, subs := range s.subs {
+		for _, conn := range subs {
+			_ = conn.Close()
+		}
+		delete(s.subs, topic)
+	}
+}
+
+func (s *standIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		args, _ := reply.([]any)
+		if len(args) == 0 {
+			return
+		}
+		command, _ := args[0].([]byte)
+		switch string(command) {
+		case "SUBSCRIBE":
+			topic := string(args[1].([]byte))
+			s.mu.Lock()
+			s.subs[topic] = append(s.subs[topic], conn)
+			s.mu.Unlock()
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(topic), topic)
+		case "PUBLISH":
+			topic := string(args[1].([]byte))
+			payload := args[2].([]byte)
+			s.mu.Lock()
+			for _, sub := range s.subs[topic] {
+				fmt.Fprintf(sub, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(topic), topic, len(payload), payload)
+			}
+			fmt.Fprintf(conn, ":%d\r\n", len(s.subs[topic]))
+			s.mu.Unlock()
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+func receive(t *testing.T, ch <-chan []byte) string {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return string(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+		return ""
+	}
+}
+
+func TestRedisReplicasReceiveEachOthersMessages(t *testing.T) {
+	server := newStandIn(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := NewRedis(server.ln.Addr().String())
+	b := NewRedis(server.ln.Addr().String())
+	defer a.Close()
+	defer b.Close()
+
+	rxA, err := a.Subscribe(ctx, "checks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rxB, err := b.Subscribe(ctx, "checks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := "{\"x\":1,\"y\":2,\"value\":true}\r\nwith a line break"
+	if err := a.Publish(ctx, "checks", []byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, rxA); got != payload {
+		t.Errorf("publisher received %q, want %q", got, payload)
+	}
+	if got := receive(t, rxB); got != payload {
+		t.Errorf("peer received %q, want %q", got, payload)
+	}
+}
+
+func TestRedisResubscribeReportsTheGap(t *testing.T) {
+	server := newStandIn(t)
+	rb := NewRedis(server.ln.Addr().String())
+	defer rb.Close()
+	rx, err := rb.Subscribe(t.Context(), "checks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.drop()
+	// A nil message tells the subscriber it has to resync before anything else arrives.
+	select {
+	case msg, ok := <-rx:
+		if !ok || msg != nil {
+			t.Fatalf("first thing after reconnecting is %q (open %v), want a nil message", msg, ok)
+		}
+	case <-time.After(redisRetryDelay + 2*time.Second):
+		t.Fatal("timed out waiting for the subscription to come back")
+	}
+	if err := rb.Publish(t.Context(), "checks", []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, rx); got != "again" {
+		t.Errorf("received %q after reconnecting, want %q", got, "again")
+	}
+}
+
+func TestRedisSubscriptionClosesWithContext(t *testing.T) {
+	server := newStandIn(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	rb := NewRedis(server.ln.Addr().String())
+	defer rb.Close()
+	rx, err := rb.Subscribe(ctx, "gameoflife")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	select {
+	case _, ok := <-rx:
+		if ok {
+			t.Fatal("expected the subscription channel to be closed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription was not closed after its context was cancelled")
+	}
+}
+
+func TestNewSelectsImplementation(t *testing.T) {
+	for url, want := range map[string]string{
+		"":                       "*broker.Memory",
+		"memory://":              "*broker.Memory",
+		"redis://localhost:6379": "*broker.Redis",
+	} {
+		b, err := New(url)
+		if err != nil {
+			t.Fatalf("New(%q): %v", url, err)
+		}
+		if got := fmt.Sprintf("%T", b); got != want {
+			t.Errorf("New(%q) = %s, want %s", url, got, want)
+		}
+		_ = b.Close()
+	}
+	if _, err := New("kafka://localhost"); err == nil {
+		t.Error("expected an error for an unsupported scheme")
+	}
+}
+
+func TestRedisPublishGivesUpOnAHungServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// Accept connections but never answer them.
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	rb := NewRedis(ln.Addr().String())
+	rb.publishTimeout = 100 * time.Millisecond
+	defer rb.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- rb.Publish(context.Background(), "checks", []byte("hello")) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected the publish to fail")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish without a deadline blocked on a server that never replied")
+	}
+}
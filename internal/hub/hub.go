@@ -0,0 +1,204 @@
+package hub
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var droppedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "hubDroppedMessages",
+	Help: "The number of messages discarded because a subscriber was not keeping up",
+}, []string{"hub"})
+
+var evictedSubscribers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "hubEvictedSubscribers",
+	Help: "The number of subscribers disconnected for missing too many messages",
+}, []string{"hub"})
+
+// Policy decides what happens when a subscriber's buffer is full at publish time.
+// A hub never blocks on a slow subscriber, so one stalled browser cannot hold up everyone else.
+type Policy int
+
+const (
+	// DropOldest discards the oldest buffered message to make room for the new one.
+	DropOldest Policy = iota
+	// Latest keeps only the most recent message. Suited to demos that publish whole states rather than deltas.
+	Latest
+	// Disconnect evicts the subscriber once it has missed MaxMissed messages in a row.
+	// Suited to demos that publish deltas, where a gap would leave the client in the wrong state.
+	Disconnect
+)
+
+// Options configure how a hub buffers messages for each of its subscribers.
+type Options struct {
+	// Buffer is the number of messages queued per subscriber. Latest always uses a buffer of 1.
+	Buffer int
+	Policy Policy
+	// MaxMissed is the number of consecutive messages a Disconnect subscriber may miss before eviction.
+	MaxMissed int
+}
+
+// Subscription is a single listener registered with a Hub.
+// Messages are delivered on C, which is closed once the subscription is removed from the hub.
+// A closed channel without the context being done means the subscriber was evicted for falling behind.
+type Subscription[T any] struct {
+	C      <-chan T
+	ch     chan T
+	filter func(T) bool
+	missed int
+	once   sync.Once
+	stop   func() bool
+}
+
+// Hub fans out every published message to all of its current subscribers.
+// It replaces the rx/addRx/delRx registries that each demo used to maintain inside its own serve() loop.
+type Hub[T any] struct {
+	name    string
+	opts    Options
+	mu      sync.Mutex
+	subs    map[*Subscription[T]]struct{}
+	changes chan int
+	// closed is set by Close, after which nothing more is published.
+	closed bool
+}
+
+func New[T any](name string, opts Options) *Hub[T] {
+	if opts.Buffer < 1 || opts.Policy == Latest {
+		opts.Buffer = 1
+	}
+	if opts.MaxMissed < 1 {
+		opts.MaxMissed = 1
+	}
+	return &Hub[T]{
+		name:    name,
+		opts:    opts,
+		subs:    make(map[*Subscription[T]]struct{}),
+		changes: make(chan int, 1),
+	}
+}
+
+// Subscribe registers a new listener with the hub.
+// The subscription is automatically removed when ctx is done, so listeners tied to a request context
+// do not need to unsubscribe explicitly.
+func (h *Hub[T]) Subscribe(ctx context.Context) *Subscription[T] {
+	return h.SubscribeFunc(ctx, nil)
+}
+
+// SubscribeFunc registers a listener that is only sent the messages for which filter returns true.
+// A nil filter receives everything.
+func (h *Hub[T]) SubscribeFunc(ctx context.Context, filter func(T) bool) *Subscription[T] {
+	ch := make(chan T, h.opts.Buffer)
+	sub := &Subscription[T]{
+		C:      ch,
+		ch:     ch,
+		filter: filter,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = struct{}{}
+	// The callback takes h.mu itself, so it cannot run before the subscription is fully registered.
+	sub.stop = context.AfterFunc(ctx, func() { h.Unsubscribe(sub) })
+	h.notify(len(h.subs))
+	return sub
+}
+
+// Unsubscribe removes the subscription from the hub and closes its channel.
+// It is safe to call more than once.
+func (h *Hub[T]) Unsubscribe(sub *Subscription[T]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+// Publish delivers msg to every current subscriber according to the hub's policy.
+// It never blocks waiting for a subscriber to read.
+func (h *Hub[T]) Publish(msg T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	for sub := range h.subs {
+		if sub.filter != nil && !sub.filter(msg) {
+			continue
+		}
+		select {
+		case sub.ch <- msg:
+			sub.missed = 0
+			continue
+		default:
+		}
+
+		switch h.opts.Policy {
+		case DropOldest, Latest:
+			// The subscriber may drain the channel concurrently, so neither step is allowed to block.
+			select {
+			case <-sub.ch:
+				droppedMessages.WithLabelValues(h.name).Inc()
+			default:
+			}
+			select {
+			case sub.ch <- msg:
+			default:
+				droppedMessages.WithLabelValues(h.name).Inc()
+			}
+
+		case Disconnect:
+			sub.missed++
+			droppedMessages.WithLabelValues(h.name).Inc()
+			if sub.missed >= h.opts.MaxMissed {
+				evictedSubscribers.WithLabelValues(h.name).Inc()
+				h.remove(sub)
+			}
+		}
+	}
+}
+
+// Close removes every subscriber and stops publishing. Hubs are often made per room, so it also deletes the hub's
+// metrics, which would otherwise keep a series for every room ever opened.
+func (h *Hub[T]) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		h.remove(sub)
+	}
+	droppedMessages.DeleteLabelValues(h.name)
+	evictedSubscribers.DeleteLabelValues(h.name)
+}
+
+// Count returns the number of active subscribers.
+func (h *Hub[T]) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Changes receives the latest subscriber count every time a subscriber joins or leaves.
+// Only the most recent count is kept, so slow readers never block the hub.
+func (h *Hub[T]) Changes() <-chan int {
+	return h.changes
+}
+
+// remove must be called with h.mu held.
+func (h *Hub[T]) remove(sub *Subscription[T]) {
+	sub.once.Do(func() {
+		sub.stop()
+		delete(h.subs, sub)
+		close(sub.ch)
+		h.notify(len(h.subs))
+	})
+}
+
+// notify must be called with h.mu held.
+func (h *Hub[T]) notify(count int) {
+	select {
+	case <-h.changes:
+	default:
+	}
+	h.changes <- count
+}
@@ -0,0 +1,227 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// receive reads the next message from sub, failing the test if none arrives in time.
+func receive[T any](t *testing.T, sub *Subscription[T]) T {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed while waiting for a message")
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+	panic("unreachable")
+}
+
+// closed waits for sub's channel to be closed, discarding anything still buffered.
+func closed[T any](t *testing.T, sub *Subscription[T]) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-sub.C:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the subscription to close")
+		}
+	}
+}
+
+func TestPublishReachesEverySubscriber(t *testing.T) {
+	h := New[int]("test", Options{Buffer: 4})
+	ctx := t.Context()
+	first, second := h.Subscribe(ctx), h.Subscribe(ctx)
+	evens := h.SubscribeFunc(ctx, func(n int) bool { return n%2 == 0 })
+
+	h.Publish(1)
+	h.Publish(2)
+	for _, sub := range []*Subscription[int]{first, second} {
+		if got := receive(t, sub); got != 1 {
+			t.Errorf("first message is %v, want 1", got)
+		}
+		if got := receive(t, sub); got != 2 {
+			t.Errorf("second message is %v, want 2", got)
+		}
+	}
+	if got := receive(t, evens); got != 2 {
+		t.Errorf("filtered subscriber got %v, want only 2", got)
+	}
+}
+
+func TestSubscriptionEndsWithItsContext(t *testing.T) {
+	h := New[int]("test", Options{Buffer: 1})
+	ctx, cancel := context.WithCancel(t.Context())
+	sub := h.Subscribe(ctx)
+	if h.Count() != 1 {
+		t.Fatalf("count is %v after subscribing, want 1", h.Count())
+	}
+
+	cancel()
+	closed(t, sub)
+	if h.Count() != 0 {
+		t.Errorf("count is %v after the context was cancelled, want 0", h.Count())
+	}
+	// Publishing to no one, and unsubscribing again, are both harmless.
+	h.Publish(1)
+	h.Unsubscribe(sub)
+}
+
+func TestPublishAfterClose(t *testing.T) {
+	h := New[int]("test", Options{Buffer: 1})
+	sub := h.Subscribe(t.Context())
+	h.Close()
+	closed(t, sub)
+
+	h.Publish(1)
+	if h.Count() != 0 {
+		t.Errorf("count is %v after closing, want 0", h.Count())
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("a message was delivered after the hub was closed")
+	}
+}
+
+// TestConcurrentUse publishes, subscribes and cancels from several goroutines at once, for the race detector.
+func TestConcurrentUse(t *testing.T) {
+	h := New[int]("test", Options{Buffer: 2})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 1000 {
+			h.Publish(i)
+		}
+	}()
+	for range 50 {
+		ctx, cancel := context.WithCancel(t.Context())
+		sub := h.Subscribe(ctx)
+		go func() {
+			for range sub.C {
+			}
+		}()
+		cancel()
+	}
+	<-done
+	h.Close()
+}
+
+// pending lists the messages already buffered for sub without waiting for more.
+func pending[T any](sub *Subscription[T]) []T {
+	msgs := make([]T, 0)
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		// want is what a subscriber that never read during the publishing finds buffered afterwards.
+		want []int
+		// evicted is whether the subscriber should have been disconnected.
+		evicted bool
+	}{
+		{name: "drop oldest keeps the newest", opts: Options{Buffer: 2, Policy: DropOldest}, want: []int{4, 5}},
+		{name: "latest keeps only the last", opts: Options{Buffer: 8, Policy: Latest}, want: []int{5}},
+		{name: "disconnect within its allowance", opts: Options{Buffer: 2, Policy: Disconnect, MaxMissed: 4}, want: []int{1, 2}},
+		{name: "disconnect past its allowance", opts: Options{Buffer: 2, Policy: Disconnect, MaxMissed: 3}, want: []int{1, 2}, evicted: true},
+		{name: "buffer large enough for everything", opts: Options{Buffer: 8, Policy: DropOldest}, want: []int{1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New[int]("test", tt.opts)
+			sub := h.Subscribe(t.Context())
+			for i := 1; i <= 5; i++ {
+				h.Publish(i)
+			}
+			if evicted := h.Count() == 0; evicted != tt.evicted {
+				t.Errorf("evicted = %v, want %v", evicted, tt.evicted)
+			}
+			got := pending(sub)
+			if len(got) != len(tt.want) {
+				t.Fatalf("buffered %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("buffered %v, want %v", got, tt.want)
+				}
+			}
+			if tt.evicted {
+				closed(t, sub)
+			}
+		})
+	}
+}
+
+func TestDisconnectForgivesAReader(t *testing.T) {
+	h := New[int]("test", Options{Buffer: 1, Policy: Disconnect, MaxMissed: 2})
+	sub := h.Subscribe(t.Context())
+	for i := range 10 {
+		h.Publish(i)
+		// Missing one message in a row and then catching up never reaches MaxMissed.
+		h.Publish(-i)
+		receive(t, sub)
+	}
+	if h.Count() != 1 {
+		t.Fatal("a subscriber that kept catching up was evicted")
+	}
+
+	h.Publish(1)
+	h.Publish(2)
+	h.Publish(3)
+	if h.Count() != 0 {
+		t.Error("a subscriber that missed two messages in a row was not evicted")
+	}
+	closed(t, sub)
+}
+
+func TestChangesCount(t *testing.T) {
+	h := New[int]("test", Options{Buffer: 1, Policy: Disconnect, MaxMissed: 1})
+	changes := func() int {
+		t.Helper()
+		select {
+		case count := <-h.Changes():
+			return count
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the count to change")
+		}
+		return 0
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	first := h.Subscribe(ctx)
+	h.Subscribe(t.Context())
+	// Only the latest count is kept, so the two joins read as one change.
+	if got := changes(); got != 2 {
+		t.Errorf("count after two subscribers joined is %v, want 2", got)
+	}
+	cancel()
+	closed(t, first)
+	if got := changes(); got != 1 {
+		t.Errorf("count after one left is %v, want 1", got)
+	}
+	// Filling the buffer and missing one more evicts the other.
+	h.Publish(1)
+	h.Publish(2)
+	if got := changes(); got != 0 {
+		t.Errorf("count after the other was evicted is %v, want 0", got)
+	}
+}
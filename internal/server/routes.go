@@ -44,10 +44,12 @@ func (s *Server) RegisterRoutes() http.Handler {
 	mux.Handle("/metrics", promhttp.Handler())
 
 	home := home.NewHandler()
-	checks := checks.NewHandler()
+	checks := checks.NewHandler(s.broker, s.dataDir, s.sessionKey)
 	clock := clock.NewHandler()
 	anim := anim.NewHandler()
-	gameoflife := gameoflife.NewHandler()
+	unbounded := gameoflife.NewUniverseHandler()
+	immigration := gameoflife.NewImmigrationHandler()
+	gameoflife := gameoflife.NewHandler(s.broker, s.gameOfLifeRooms)
 
 	mux.Handle("/", middleware.Then(home))
 	mux.Handle("/checks", middleware.Then(checks))
@@ -55,6 +57,11 @@ func (s *Server) RegisterRoutes() http.Handler {
 	mux.Handle("/clock", middleware.Then(clock))
 	mux.Handle("/anim", middleware.Then(anim))
 	mux.Handle("/gameoflife", middleware.Then(gameoflife))
+	mux.Handle("/gameoflife/lobby", middleware.Then(gameoflife.Lobby()))
+	mux.Handle("/gameoflife/new", middleware.Then(gameoflife.New()))
+	mux.Handle("/gameoflife/unbounded", middleware.Then(unbounded))
+	mux.Handle("/gameoflife/immigration", middleware.Then(immigration))
+	mux.Handle("/gameoflife/{room}", middleware.Then(gameoflife))
 	// Wrap the mux with CORS middleware
 	return mux
 }
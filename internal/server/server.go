@@ -1,6 +1,8 @@
 package server
 
 import (
+	"apparently-experiments/internal/broker"
+	"apparently-experiments/internal/views/gameoflife"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,7 +13,14 @@ import (
 )
 
 type Server struct {
-	port int
+	port   int
+	broker broker.Broker
+	// dataDir is where demos persist their state. Empty keeps everything in memory.
+	dataDir string
+	// gameOfLifeRooms caps how many game of life rooms are open at once.
+	gameOfLifeRooms int
+	// sessionKey keys the hash recorded in place of a session, so every replica records the same browser the same way.
+	sessionKey string
 }
 
 func NewServer() *http.Server {
@@ -19,8 +28,24 @@ func NewServer() *http.Server {
 	if err != nil {
 		panic(err)
 	}
+	// BROKER_URL is only needed when running several replicas, e.g. redis://redis:6379
+	broker, err := broker.New(os.Getenv("BROKER_URL"))
+	if err != nil {
+		panic(err)
+	}
+	gameOfLifeRooms := gameoflife.DefaultMaxRooms
+	if rooms := os.Getenv("GAMEOFLIFE_MAX_ROOMS"); rooms != "" {
+		gameOfLifeRooms, err = strconv.Atoi(rooms)
+		if err != nil {
+			panic(err)
+		}
+	}
 	newServer := &Server{
-		port: port,
+		port:            port,
+		broker:          broker,
+		dataDir:         os.Getenv("DATA_DIR"),
+		gameOfLifeRooms: gameOfLifeRooms,
+		sessionKey:      os.Getenv("SESSION_KEY"),
 	}
 
 	// Declare Server config
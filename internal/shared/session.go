@@ -0,0 +1,33 @@
+package shared
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SessionCookie is the cookie a demo keeps its anonymous session ids in. Each demo scopes its own cookie to its path,
+// so a session is only ever sent to the demo that issued it.
+type SessionCookie struct {
+	Name string
+	Path string
+}
+
+// Session returns the anonymous id of the browser making the request, issuing one if it has none.
+// It must be called before anything is written to w.
+func (c SessionCookie) Session(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(c.Name); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	id := uuid.New().String()
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.Name,
+		Value:    id,
+		Path:     c.Path,
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
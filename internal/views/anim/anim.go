@@ -1,6 +1,7 @@
 package anim
 
 import (
+	"apparently-experiments/internal/hub"
 	"apparently-experiments/internal/shared"
 	"log/slog"
 	"math"
@@ -13,7 +14,6 @@ import (
 )
 
 const (
-	channelBuffer  = 10
 	ticksPerSecond = 30
 )
 
@@ -26,19 +26,16 @@ type AnimationState struct {
 	y_pos int
 }
 type Handler struct {
-	rw    sync.RWMutex
-	rx    []chan *AnimationState
-	addRx chan chan *AnimationState
-	delRx chan (<-chan *AnimationState)
-	anim  AnimationState
+	rw   sync.RWMutex
+	hub  *hub.Hub[AnimationState]
+	anim AnimationState
 }
 
 func NewHandler() http.Handler {
 	h := &Handler{
-		rw:    sync.RWMutex{},
-		rx:    make([]chan *AnimationState, 0),
-		addRx: make(chan chan *AnimationState, channelBuffer),
-		delRx: make(chan (<-chan *AnimationState), channelBuffer),
+		rw: sync.RWMutex{},
+		// Every frame supersedes the last, so a slow viewer only ever needs the newest one.
+		hub: hub.New[AnimationState]("anim", hub.Options{Policy: hub.Latest}),
 		anim: AnimationState{
 			tick:  0,
 			red:   255,
@@ -74,42 +71,31 @@ func (h *Handler) serve() {
 	ticker := time.NewTicker(time.Second / ticksPerSecond)
 	defer ticker.Stop()
 
+	viewers := 0
 	for {
 		select {
 		case <-ticker.C:
 			// Pause the animation if no one is watching
 			// Clean up the ticker during this time.
-			if len(h.rx) == 0 {
+			if h.hub.Count() == 0 {
 				ticker.Stop()
 				continue
 			}
 			h.tickAnimation()
 
+			// Publish a copy so listeners can render without holding the state lock.
 			h.rw.RLock()
-
-			for _, rx := range h.rx {
-				rx <- &h.anim
-			}
+			frame := h.anim
 			h.rw.RUnlock()
+			h.hub.Publish(frame)
 
-		case channel := <-h.addRx:
-			slog.Debug("Opening channel")
+		case count := <-h.hub.Changes():
+			slog.Debug("Animation viewers changed", "viewers", count)
 			// If this is the first viewer, start the animation.
-			if len(h.rx) == 0 {
+			if viewers == 0 && count > 0 {
 				ticker.Reset(time.Second / ticksPerSecond)
 			}
-			h.rx = append(h.rx, channel)
-
-		case channel := <-h.delRx:
-			slog.Debug("Closing channel")
-			for i, ch := range h.rx {
-				if ch == channel {
-					h.rx[i] = h.rx[len(h.rx)-1]
-					h.rx = h.rx[:len(h.rx)-1]
-					close(ch)
-					break
-				}
-			}
+			viewers = count
 		}
 	}
 }
@@ -139,8 +125,7 @@ func (h *Handler) listen(w http.ResponseWriter, r *http.Request) {
 		_ = sse.ConsoleError(err)
 		return
 	}
-	listener := make(chan *AnimationState)
-	h.addRx <- listener
+	listener := h.hub.Subscribe(sse.Context())
 	slog.Debug("Animation listener connected", "request_id", requestId)
 	// Keep the context open until the connection closes (detectable via the request context)
 	for {
@@ -148,11 +133,13 @@ func (h *Handler) listen(w http.ResponseWriter, r *http.Request) {
 
 		case <-sse.Context().Done():
 			slog.Debug("Animation listener disconnected", "request_id", requestId)
-			h.delRx <- listener
 			return
 
-		case msg := <-listener:
-			err := sse.PatchElementTempl(AnimationFragment(msg))
+		case msg, ok := <-listener.C:
+			if !ok {
+				return
+			}
+			err := sse.PatchElementTempl(AnimationFragment(&msg))
 			if err != nil {
 				slog.Error("Error occurred when patching", "error", err, "request_id", requestId)
 			}
@@ -0,0 +1,214 @@
+package checks
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const URI_PARAM_FORMAT string = "format"
+const URI_PARAM_ENCODING string = "encoding"
+const URI_PARAM_SCALE string = "scale"
+
+const (
+	mediaTypeJSON = "application/json"
+	mediaTypePNG  = "image/png"
+	encodingDense = "dense"
+	encodingRLE   = "rle"
+)
+
+// The largest number of pixels each checkbox may be drawn with, and the largest image side that may be asked for.
+const maxScale = 16
+const maxImageSide = 4096
+
+// The largest JSON update body accepted, comfortably more than a full batch.
+const maxAPIBody = 1 << 20
+
+// accepts reports whether the request asks for mediaType, either with format=json or format=png
+// or by naming it in its Accept header. Datastar's own requests accept JSON but always want the page.
+func accepts(r *http.Request, mediaType string) bool {
+	if format := r.URL.Query().Get(URI_PARAM_FORMAT); format != "" {
+		return strings.HasSuffix(mediaType, "/"+format)
+	}
+	if r.Header.Get("Datastar-Request") == "true" {
+		return false
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if accepted, _, err := mime.ParseMediaType(part); err == nil && accepted == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// isAPIUpdate reports whether a POST is a JSON update from a bot rather than a datastar action, which also sends JSON.
+func isAPIUpdate(r *http.Request) bool {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return contentType == mediaTypeJSON && r.Header.Get("Datastar-Request") != "true"
+}
+
+// BoardJSON is the board returned to API clients. Cells are listed row by row.
+// The dense encoding sets Cells and Versions, with 1 for a checked box. The run-length encoding sets Runs instead,
+// the lengths of alternating runs of unchecked and checked boxes starting with unchecked.
+type BoardJSON struct {
+	Width    uint     `json:"width"`
+	Height   uint     `json:"height"`
+	Seq      uint64   `json:"seq"`
+	Encoding string   `json:"encoding"`
+	Cells    []int    `json:"cells,omitempty"`
+	Versions []uint32 `json:"versions,omitempty"`
+	Runs     []uint   `json:"runs,omitempty"`
+}
+
+func (rm *room) serveJSON(w http.ResponseWriter, r *http.Request) {
+	width, height := rm.checkboxes.Width(), rm.checkboxes.Height()
+	window := rm.checkboxes.Window(Viewport{Width: width, Height: height})
+	board := BoardJSON{Width: width, Height: height, Seq: window.Seq}
+
+	switch encoding := r.URL.Query().Get(URI_PARAM_ENCODING); encoding {
+	case "", encodingDense:
+		board.Encoding = encodingDense
+		board.Cells = make([]int, len(window.cells))
+		board.Versions = make([]uint32, len(window.cells))
+		for i, cell := range window.cells {
+			if cell.Value {
+				board.Cells[i] = 1
+			}
+			board.Versions[i] = cell.Version
+		}
+	case encodingRLE:
+		board.Encoding = encodingRLE
+		board.Runs = []uint{0}
+		current := false
+		for _, cell := range window.cells {
+			if cell.Value != current {
+				board.Runs = append(board.Runs, 0)
+				current = cell.Value
+			}
+			board.Runs[len(board.Runs)-1]++
+		}
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unknown %v %q, expected %v or %v", URI_PARAM_ENCODING, encoding, encodingDense, encodingRLE))
+		return
+	}
+	writeJSON(w, http.StatusOK, board)
+}
+
+// servePNG draws the board with a white pixel for every checked box, scaled up by the scale parameter.
+func (rm *room) servePNG(w http.ResponseWriter, r *http.Request) {
+	width, height := rm.checkboxes.Width(), rm.checkboxes.Height()
+	scale := uint(1)
+	if r.URL.Query().Has(URI_PARAM_SCALE) {
+		parsed, err := strconv.ParseUint(r.URL.Query().Get(URI_PARAM_SCALE), 10, 0)
+		if err != nil || parsed < 1 || parsed > maxScale || uint(parsed)*max(width, height) > maxImageSide {
+			http.Error(w, fmt.Sprintf("%v must be between 1 and %v and keep the image within %vpx", URI_PARAM_SCALE, maxScale, maxImageSide), http.StatusBadRequest)
+			return
+		}
+		scale = uint(parsed)
+	}
+
+	values, _ := rm.checkboxes.Snapshot()
+	img := image.NewPaletted(image.Rect(0, 0, int(width*scale), int(height*scale)), color.Palette{color.Black, color.White})
+	for y := range height * scale {
+		for x := range width * scale {
+			if values.Get((y/scale)*width + x/scale) {
+				img.SetColorIndex(int(x), int(y), 1)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", mediaTypePNG)
+	if err := png.Encode(w, img); err != nil {
+		slog.Error("Failed to write checkbox image", "board", rm.name, "error", err)
+	}
+}
+
+// apiUpdate is either a single update or a batch of changes.
+type apiUpdate struct {
+	X        *uint     `json:"x"`
+	Y        *uint     `json:"y"`
+	Value    bool      `json:"value"`
+	Expected *uint32   `json:"expected"`
+	Changes  []Message `json:"changes"`
+}
+
+// apiResult reports what happened to a JSON update. Cell is the checkbox after a compare-and-set, whether it was applied or not.
+type apiResult struct {
+	Accepted bool  `json:"accepted"`
+	Count    int   `json:"count"`
+	Cell     *Cell `json:"cell,omitempty"`
+}
+
+// updateJSON applies updates posted as JSON with the same validation as the page's own updates.
+// A batch, or a single update without an expected version, is accepted as soon as it is published.
+// A single compare-and-set waits for the verdict and answers 409 Conflict with the current cell when it is rejected.
+func (rm *room) updateJSON(w http.ResponseWriter, r *http.Request) {
+	sessionID := sessionCookie.Session(w, r)
+	update := apiUpdate{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody)).Decode(&update); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	msgs := update.Changes
+	if msgs == nil {
+		if update.X == nil || update.Y == nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("expected x and y, or a list of changes"))
+			return
+		}
+		msgs = []Message{{X: *update.X, Y: *update.Y, Value: update.Value, Expected: update.Expected}}
+	}
+	if len(msgs) == 0 {
+		writeJSON(w, http.StatusAccepted, apiResult{Accepted: true})
+		return
+	}
+	if err := rm.prepare(r, sessionID, msgs); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(msgs) > 1 || msgs[0].Expected == nil {
+		if err := rm.publish(r.Context(), replicaMessage{Updates: msgs}); err != nil {
+			slog.Error("Failed to publish checkbox update", "error", err)
+			writeJSONError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, apiResult{Accepted: true, Count: len(msgs)})
+		return
+	}
+
+	accepted, err := rm.submit(r.Context(), msgs[0])
+	if err != nil {
+		if r.Context().Err() == nil {
+			slog.Error("Failed to publish checkbox update", "error", err)
+			writeJSONError(w, http.StatusServiceUnavailable, err)
+		}
+		return
+	}
+	cell := rm.checkboxes.Cell(msgs[0].X, msgs[0].Y)
+	status := http.StatusOK
+	if !accepted {
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, apiResult{Accepted: accepted, Count: 1, Cell: &cell})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", mediaTypeJSON)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write JSON response", "error", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,75 @@
+package checks
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Bitset packs booleans 64 to a word so a 1000x1000 grid fits in 125KB rather than a megabyte of bools.
+type Bitset struct {
+	size  uint
+	words []uint64
+}
+
+func NewBitset(size uint) Bitset {
+	return Bitset{
+		size:  size,
+		words: make([]uint64, (size+63)/64),
+	}
+}
+
+func (b *Bitset) Len() uint {
+	return b.size
+}
+
+func (b *Bitset) Get(i uint) bool {
+	return b.words[i/64]&(1<<(i%64)) != 0
+}
+
+func (b *Bitset) Set(i uint, value bool) {
+	if value {
+		b.words[i/64] |= 1 << (i % 64)
+	} else {
+		b.words[i/64] &^= 1 << (i % 64)
+	}
+}
+
+// Count returns the number of set bits.
+func (b *Bitset) Count() uint {
+	count := 0
+	for _, word := range b.words {
+		count += bits.OnesCount64(word)
+	}
+	return uint(count)
+}
+
+func (b *Bitset) Clone() Bitset {
+	words := make([]uint64, len(b.words))
+	copy(words, b.words)
+	return Bitset{size: b.size, words: words}
+}
+
+// Bytes packs the bits eight to a byte, with bit i stored in byte i/8 at position i%8.
+func (b *Bitset) Bytes() []byte {
+	packed := make([]byte, (b.size+7)/8)
+	for i := range packed {
+		packed[i] = byte(b.words[i/8] >> (8 * (i % 8)))
+	}
+	return packed
+}
+
+// BitsetFromBytes reverses Bytes.
+func BitsetFromBytes(size uint, packed []byte) (Bitset, error) {
+	b := NewBitset(size)
+	if uint(len(packed)) != (size+7)/8 {
+		return b, fmt.Errorf("packed bitset is %v bytes, expected %v", len(packed), (size+7)/8)
+	}
+	for i, value := range packed {
+		b.words[i/8] |= uint64(value) << (8 * (i % 8))
+	}
+	// Ignore any stray bits past the end so Count stays accurate.
+	if size%64 != 0 {
+		b.words[len(b.words)-1] &= 1<<(size%64) - 1
+	}
+	return b, nil
+}
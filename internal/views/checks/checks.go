@@ -1,126 +1,434 @@
 package checks
 
 import (
+	"apparently-experiments/internal/broker"
+	"apparently-experiments/internal/hub"
 	"apparently-experiments/internal/shared"
+	"apparently-experiments/internal/views/presence"
+	"context"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/a-h/templ"
+	"github.com/google/uuid"
 	"github.com/starfederation/datastar-go/datastar"
 )
 
 const X_DIMENSION uint = 20
 const Y_DIMENSION uint = 20
 
+// The million checkbox board is far too large to send whole, so listeners only stream the viewport they are scrolled to.
+const MILLION_DIMENSION uint = 1000
+const VIEWPORT_WIDTH uint = 40
+const VIEWPORT_HEIGHT uint = 25
+
 const URI_PARAM_LISTEN string = "listen"
 const URI_PARAM_ID string = "id"
 const URI_PARAM_X string = "x"
 const URI_PARAM_Y string = "y"
 const URI_PARAM_STATE string = "state"
+const URI_PARAM_BATCH string = "batch"
+const URI_PARAM_VERSION string = "version"
+
+// How long a compare-and-set request waits to hear whether it was applied.
+const casTimeout = 5 * time.Second
+
+// The most cell changes accepted in one batch, enough to paint a whole viewport.
+const maxBatchSize = 1000
+
+// Updates arriving within this window of each other are sent to a listener as one event.
+const coalesceWindow = 50 * time.Millisecond
 
 const channelBuffer uint = 10
 
+// Each listener may queue this many updates before it is considered stalled.
+const listenerBuffer = 64
+
+// A missed delta leaves the browser showing the wrong board, so stalled listeners are kicked
+// and resynchronised with a full fragment as soon as they miss one.
+const maxMissedUpdates = 1
+
+// Cell is a single checkbox. Version counts the changes made to it so that clients can
+// make compare-and-set updates against the state they last saw.
+type Cell struct {
+	Value   bool   `json:"value"`
+	Version uint32 `json:"version"`
+}
+
+// SyncMap is a width by height grid of checkboxes backed by a bitset.
 type SyncMap struct {
 	rw     sync.RWMutex
-	values [X_DIMENSION][Y_DIMENSION]bool
+	width  uint
+	height uint
+	values Bitset
+	// versions holds the version of each cell that has ever changed, keyed by its index. Every other cell is at version 0,
+	// so a large board nobody has touched costs no more than its bitset.
+	versions map[uint]uint32
+	// seq is the sequence id of the last update applied to values.
+	seq uint64
 }
 
-func NewSyncMap() SyncMap {
-	return SyncMap{
-		rw:     sync.RWMutex{},
-		values: [X_DIMENSION][Y_DIMENSION]bool{},
+func NewSyncMap(width, height uint) *SyncMap {
+	return &SyncMap{
+		rw:       sync.RWMutex{},
+		width:    width,
+		height:   height,
+		values:   NewBitset(width * height),
+		versions: make(map[uint]uint32),
 	}
 }
 
+func (sm *SyncMap) Width() uint {
+	return sm.width
+}
+
+func (sm *SyncMap) Height() uint {
+	return sm.height
+}
+
 func (sm *SyncMap) Get(x, y uint) bool {
 	sm.rw.RLock()
 	defer sm.rw.RUnlock()
-	return sm.values[x][y]
+	return sm.values.Get(y*sm.width + x)
+}
+
+func (sm *SyncMap) Cell(x, y uint) Cell {
+	sm.rw.RLock()
+	defer sm.rw.RUnlock()
+	i := y*sm.width + x
+	return Cell{Value: sm.values.Get(i), Version: sm.versions[i]}
 }
-func (sm *SyncMap) Set(x, y uint, value bool) {
+
+// Set updates a checkbox and returns the sequence id assigned to the update along with the cell's new version.
+func (sm *SyncMap) Set(x, y uint, value bool) (uint64, uint32) {
 	slog.Debug("Update message received adding to broadcasting", "x", x, "y", y, "value", value)
 	sm.rw.Lock()
 	defer sm.rw.Unlock()
-	sm.values[x][y] = value
+	i := y*sm.width + x
+	sm.values.Set(i, value)
+	sm.versions[i]++
+	sm.seq++
+	return sm.seq, sm.versions[i]
+}
+
+// Restore overwrites a checkbox with a value and version taken from another replica.
+func (sm *SyncMap) Restore(x, y uint, cell Cell) uint64 {
+	sm.rw.Lock()
+	defer sm.rw.Unlock()
+	i := y*sm.width + x
+	sm.values.Set(i, cell.Value)
+	sm.versions[i] = cell.Version
+	sm.seq++
+	return sm.seq
+}
+
+// Window returns a consistent copy of the checkboxes inside view, including the sequence id it reflects.
+func (sm *SyncMap) Window(view Viewport) *Window {
+	sm.rw.RLock()
+	defer sm.rw.RUnlock()
+	window := &Window{
+		View:  view,
+		Seq:   sm.seq,
+		cells: make([]Cell, 0, view.Width*view.Height),
+	}
+	for y := view.Y; y < view.Y+view.Height; y++ {
+		for x := view.X; x < view.X+view.Width; x++ {
+			i := y*sm.width + x
+			window.cells = append(window.cells, Cell{Value: sm.values.Get(i), Version: sm.versions[i]})
+		}
+	}
+	return window
+}
+
+// Snapshot returns a copy of every checkbox value, indexed row by row, and the version of every cell that has changed.
+func (sm *SyncMap) Snapshot() (Bitset, map[uint]uint32) {
+	sm.rw.RLock()
+	defer sm.rw.RUnlock()
+	return sm.values.Clone(), maps.Clone(sm.versions)
 }
 
 type Message struct {
-	X     uint `json:"x"`
-	Y     uint `json:"y"`
-	Value bool `json:"value"`
+	// Seq is assigned by each replica as it applies the update and is sent to listeners as the SSE event id.
+	Seq   uint64 `json:"seq"`
+	X     uint   `json:"x"`
+	Y     uint   `json:"y"`
+	Value bool   `json:"value"`
+	// Version is the cell's version once the update has been applied.
+	Version uint32 `json:"version"`
+	// Expected makes the update a compare-and-set, which is rejected unless the cell is still at this version.
+	Expected *uint32 `json:"expected,omitempty"`
+	// RequestID lets the replica that received the request tell the waiting client whether it was applied,
+	// and is recorded in the history along with Time and Session.
+	RequestID string `json:"requestId,omitempty"`
+	// Time is when the replica that received the request accepted it.
+	Time time.Time `json:"time,omitzero"`
+	// Session identifies the browser that made the change by a hash of its session, never the session itself.
+	Session string `json:"session,omitempty"`
 }
 
-type Handler struct {
-	checkboxes SyncMap
-	tx         chan Message
-	rx         []chan Message
-	addRx      chan chan Message
-	delRx      chan (<-chan Message)
+// room is a single shared board with its own listeners, broker topic and persisted files.
+type room struct {
+	// name identifies the board in broker topics and persisted files.
+	name string
+	// basePath is the URL the board is served from.
+	basePath   string
+	checkboxes *SyncMap
+	tx         chan []Message
+	sync       chan replicaMessage
+	hub        *hub.Hub[Message]
+	replay     replayRing
+	broker     broker.Broker
+	// store is nil when no data directory is configured and the grid only lives in memory.
+	store *store
+	// history records every change made to the board.
+	history *history
+	// auditKey keys the hash of a browser's session that is recorded in the history.
+	auditKey []byte
+	// presence tracks who is watching the board and where their cursors are.
+	presence *presence.Tracker
+	// origin identifies this replica in broker messages.
+	origin string
+	// epoch distinguishes this process's event ids from those of earlier processes.
+	epoch string
+	// synced is set once a board has been adopted from another replica. Only touched by serve().
+	synced bool
+	// pending holds the result channel of each compare-and-set request waiting to be applied, keyed by request id.
+	pending sync.Map
+	// casTimeout bounds how long a compare-and-set request waits for its result.
+	casTimeout time.Duration
+	// lastActive is when the room last served a request, in unix nanoseconds.
+	lastActive atomic.Int64
+	ctx        context.Context
+	cancel     context.CancelFunc
+	// done is closed once serve() has stopped and closed everything it owns.
+	done chan struct{}
 }
 
-func NewHandler() http.Handler {
-	h := &Handler{
-		checkboxes: NewSyncMap(),
-		tx:         make(chan Message, channelBuffer),
-		rx:         make([]chan Message, 0),
-		addRx:      make(chan chan Message, channelBuffer),
-		delRx:      make(chan (<-chan Message), channelBuffer),
+func newRoom(name, basePath string, width, height uint, b broker.Broker, dataDir string, auditKey []byte) (*room, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rm := &room{
+		name:       name,
+		basePath:   basePath,
+		checkboxes: NewSyncMap(width, height),
+		tx:         make(chan []Message, channelBuffer),
+		sync:       make(chan replicaMessage, channelBuffer),
+		broker:     b,
+		auditKey:   auditKey,
+		presence:   presence.New("checks:" + name),
+		origin:     uuid.New().String(),
+		epoch:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		casTimeout: casTimeout,
+		hub: hub.New[Message]("checks:"+name, hub.Options{
+			Buffer:    listenerBuffer,
+			Policy:    hub.Disconnect,
+			MaxMissed: maxMissedUpdates,
+		}),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	rm.touch()
+	if dataDir != "" {
+		store, values, versions, err := openStore(dataDir, name, width, height)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("%v recovery failed: %w", name, err)
+		}
+		rm.store = store
+		rm.checkboxes.values = values
+		rm.checkboxes.versions = versions
+	}
+	values, _ := rm.checkboxes.Snapshot()
+	history, err := openHistory(dataDir, name, width, height, values)
+	if err != nil {
+		cancel()
+		if rm.store != nil {
+			_ = rm.store.Close()
+		}
+		return nil, fmt.Errorf("%v history recovery failed: %w", name, err)
+	}
+	rm.history = history
+	updates, err := b.Subscribe(ctx, rm.name)
+	if err != nil {
+		cancel()
+		if rm.store != nil {
+			_ = rm.store.Close()
+		}
+		_ = rm.history.Close()
+		return nil, fmt.Errorf("%v broker subscription failed: %w", name, err)
 	}
-	go h.serve()
-	return h
+	go rm.receive(updates)
+	go rm.serve()
+	if err := rm.publish(ctx, replicaMessage{SyncRequest: true}); err != nil {
+		slog.Error("Failed to request checks board from replicas", "board", name, "error", err)
+	}
+	return rm, nil
+}
+
+func (rm *room) touch() {
+	rm.lastActive.Store(time.Now().UnixNano())
+}
+
+// idle reports whether nobody is watching the room and it has not been used for at least timeout.
+func (rm *room) idle(timeout time.Duration) bool {
+	return rm.hub.Count() == 0 && time.Since(time.Unix(0, rm.lastActive.Load())) >= timeout
 }
 
-func (h *Handler) serve() {
-	slog.Debug("Checks updater worker started")
+// close stops the room's workers and waits for serve() to close its store, history, hub and presence.
+func (rm *room) close() {
+	rm.cancel()
+	<-rm.done
+}
+
+func (rm *room) serve() {
+	defer close(rm.done)
+	slog.Debug("Checks updater worker started", "board", rm.name)
 	for {
 		select {
-		case msg := <-h.tx:
-			slog.Debug("Update message received adding to broadcasting", "x", msg.X, "y", msg.Y, "value", msg.Value)
-			h.checkboxes.Set(msg.X, msg.Y, msg.Value)
-			for _, rx := range h.rx {
-				rx <- msg
+		case <-rm.ctx.Done():
+			slog.Debug("Checks updater worker stopped", "board", rm.name)
+			if rm.store != nil {
+				if err := rm.store.Close(); err != nil {
+					slog.Error("Failed to close checkbox store", "board", rm.name, "error", err)
+				}
 			}
+			if err := rm.history.Close(); err != nil {
+				slog.Error("Failed to close checkbox history", "board", rm.name, "error", err)
+			}
+			rm.hub.Close()
+			rm.presence.Close()
+			return
+		case msgs := <-rm.tx:
+			slog.Debug("Update messages received adding to broadcasting", "board", rm.name, "count", len(msgs))
+			rm.apply(msgs)
+		case msg := <-rm.sync:
+			rm.handleSync(msg)
+		}
+	}
+}
 
-		case channel := <-h.addRx:
-			slog.Debug("Opening channel")
-			h.rx = append(h.rx, channel)
-
-		case channel := <-h.delRx:
-			slog.Debug("Closing channel")
-			for i, ch := range h.rx {
-				if ch == channel {
-					h.rx[i] = h.rx[len(h.rx)-1]
-					h.rx = h.rx[:len(h.rx)-1]
-					close(ch)
-					break
-				}
+// apply persists and broadcasts a batch of updates. Compare-and-set updates against a stale version are dropped,
+// and every replica reaches the same verdict because they all apply updates in the broker's order.
+func (rm *room) apply(msgs []Message) {
+	accepted := make([]Message, 0, len(msgs))
+	// A batch may touch the same checkbox twice, so later entries are checked against the earlier ones.
+	bumped := make(map[[2]uint]uint32)
+	for _, msg := range msgs {
+		key := [2]uint{msg.X, msg.Y}
+		if msg.Expected != nil && rm.checkboxes.Cell(msg.X, msg.Y).Version+bumped[key] != *msg.Expected {
+			slog.Debug("Rejected stale checkbox update", "board", rm.name, "x", msg.X, "y", msg.Y, "expected", *msg.Expected)
+			rm.report(msg, false)
+			continue
+		}
+		bumped[key]++
+		msg.Version = rm.checkboxes.Cell(msg.X, msg.Y).Version + bumped[key]
+		accepted = append(accepted, msg)
+	}
+	if len(accepted) == 0 {
+		return
+	}
+
+	if rm.store != nil {
+		if err := rm.store.Append(accepted...); err != nil {
+			// An update this replica cannot persist would be lost on restart, so the whole batch is turned away.
+			slog.Error("Rejected checkbox updates that could not be persisted", "board", rm.name, "count", len(accepted), "error", err)
+			for _, msg := range accepted {
+				rm.report(msg, false)
 			}
+			return
 		}
 	}
+	changes := make([]Change, len(accepted))
+	for i, msg := range accepted {
+		if msg.Time.IsZero() {
+			msg.Time = time.Now()
+		}
+		changes[i] = Change{Time: msg.Time, X: msg.X, Y: msg.Y, Value: msg.Value, Session: msg.Session, RequestID: msg.RequestID}
+	}
+	rm.history.Record(changes...)
+	for _, msg := range accepted {
+		rm.broadcast(msg)
+		rm.report(msg, true)
+	}
+
+	if rm.store != nil && rm.store.NeedsCompaction() {
+		rm.compact()
+	}
+}
+
+// report tells a request waiting on this replica whether its update was applied.
+func (rm *room) report(msg Message, accepted bool) {
+	if msg.RequestID == "" {
+		return
+	}
+	if result, ok := rm.pending.LoadAndDelete(msg.RequestID); ok {
+		result.(chan bool) <- accepted
+	}
 }
 
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// broadcast applies msg to the in memory grid and sends it to listeners without persisting it.
+func (rm *room) broadcast(msg Message) {
+	msg.Seq, msg.Version = rm.checkboxes.Set(msg.X, msg.Y, msg.Value)
+	rm.announce(msg)
+}
+
+// announce sends an update that has already been applied to the grid to listeners.
+func (rm *room) announce(msg Message) {
+	msg.Expected = nil
+	msg.RequestID = ""
+	rm.replay.Add(msg)
+	rm.hub.Publish(msg)
+}
+
+func (rm *room) compact() {
+	values, versions := rm.checkboxes.Snapshot()
+	if err := rm.store.Compact(&values, versions); err != nil {
+		slog.Error("Failed to compact checkbox write-ahead log", "board", rm.name, "error", err)
+	}
+}
+
+func (rm *room) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 
 	case http.MethodGet:
 		if r.URL.Query().Has(URI_PARAM_LISTEN) {
-			h.listen(w, r)
+			rm.listen(w, r)
+		} else if r.URL.Query().Has(URI_PARAM_HISTORY) {
+			rm.serveHistory(w, r)
+		} else if accepts(r, mediaTypeJSON) {
+			rm.serveJSON(w, r)
+		} else if accepts(r, mediaTypePNG) {
+			rm.servePNG(w, r)
 		} else {
-			templ.Handler(Checkboxes(&h.checkboxes, X_DIMENSION, Y_DIMENSION)).ServeHTTP(w, r)
+			window := rm.checkboxes.Window(rm.viewport(0, 0))
+			templ.Handler(Checkboxes(rm.basePath, rm.checkboxes, window, rm.presence.Count())).ServeHTTP(w, r)
 		}
 	case http.MethodPost:
-		h.update(w, r)
+		if isAPIUpdate(r) {
+			rm.updateJSON(w, r)
+		} else if r.URL.Query().Has(URI_PARAM_BATCH) {
+			rm.updateBatch(w, r)
+		} else if r.URL.Query().Has(presence.URI_PARAM_CURSOR) {
+			rm.presence.ServeCursor(w, r, sessionCookie.Session(w, r))
+		} else {
+			rm.update(w, r)
+		}
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 }
-func (h *Handler) update(w http.ResponseWriter, r *http.Request) {
+func (rm *room) update(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("Checkbox update sent")
+	sessionID := sessionCookie.Session(w, r)
 	sse := datastar.NewSSE(w, r)
 
 	x, err := strconv.Atoi(r.URL.Query().Get(URI_PARAM_X))
@@ -140,9 +448,8 @@ func (h *Handler) update(w http.ResponseWriter, r *http.Request) {
 		_ = sse.ConsoleError(fmt.Errorf("internal error %v", err))
 		return
 	}
-	if uint(x) >= X_DIMENSION || uint(y) >= Y_DIMENSION {
-		_ = sse.ConsoleError(fmt.Errorf("coordinates out of bounds: x=%d, y=%d (max: %d,%d)",
-			x, y, X_DIMENSION-1, Y_DIMENSION-1))
+	if x < 0 || y < 0 {
+		_ = sse.ConsoleError(fmt.Errorf("coordinates out of bounds: x=%d, y=%d", x, y))
 		return
 	}
 	msg := Message{
@@ -150,35 +457,267 @@ func (h *Handler) update(w http.ResponseWriter, r *http.Request) {
 		Y:     uint(y),
 		Value: state,
 	}
-	slog.Debug("message injested", "message", msg)
-	h.tx <- msg
+	// With a version the update only applies if nobody else has changed the checkbox since the client saw it.
+	if r.URL.Query().Has(URI_PARAM_VERSION) {
+		version, err := strconv.ParseUint(r.URL.Query().Get(URI_PARAM_VERSION), 10, 32)
+		if err != nil {
+			_ = sse.ConsoleError(err)
+			return
+		}
+		expected := uint32(version)
+		msg.Expected = &expected
+	}
+	msgs := []Message{msg}
+	if err := rm.prepare(r, sessionID, msgs); err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	slog.Debug("message injested", "message", msgs[0])
+
+	accepted, err := rm.submit(sse.Context(), msgs[0])
+	if err != nil {
+		if sse.Context().Err() == nil {
+			slog.Error("Failed to publish checkbox update", "error", err)
+			_ = sse.ConsoleError(err)
+		}
+		return
+	}
+	if accepted {
+		// Listeners, this client included, are sent the new state through listen().
+		return
+	}
+	// Someone else got there first, so put the client's checkbox back to how it really is.
+	cell := rm.checkboxes.Cell(msg.X, msg.Y)
+	if err := sse.PatchElementTempl(Checkbox(rm.basePath, msg.X, msg.Y, cell)); err != nil {
+		_ = sse.ConsoleError(err)
+	}
+}
+
+// submit publishes a single update. A compare-and-set waits to hear whether it was applied, and a timeout
+// is reported as a rejection since the client cannot tell whether its version was still current.
+func (rm *room) submit(ctx context.Context, msg Message) (bool, error) {
+	if msg.Expected == nil {
+		return true, rm.publish(ctx, replicaMessage{Updates: []Message{msg}})
+	}
+
+	if msg.RequestID == "" {
+		msg.RequestID = uuid.New().String()
+	}
+	result := make(chan bool, 1)
+	rm.pending.Store(msg.RequestID, result)
+	defer rm.pending.Delete(msg.RequestID)
+	if err := rm.publish(ctx, replicaMessage{Updates: []Message{msg}}); err != nil {
+		return false, err
+	}
+
+	select {
+	case accepted := <-result:
+		return accepted, nil
+	case <-time.After(rm.casTimeout):
+		slog.Warn("Timed out waiting for checkbox update", "board", rm.name, "x", msg.X, "y", msg.Y)
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+type batchSignals struct {
+	Changes []Message `json:"changes"`
+}
+
+// updateBatch applies every change in the changes signal together, e.g. the cells painted by dragging across the grid.
+func (rm *room) updateBatch(w http.ResponseWriter, r *http.Request) {
+	signals := batchSignals{}
+	err := datastar.ReadSignals(r, &signals)
+	sessionID := sessionCookie.Session(w, r)
+	sse := datastar.NewSSE(w, r)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	if len(signals.Changes) == 0 {
+		return
+	}
+	if err := rm.prepare(r, sessionID, signals.Changes); err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	slog.Debug("batch injested", "board", rm.name, "count", len(signals.Changes))
+	if err := rm.publish(r.Context(), replicaMessage{Updates: signals.Changes}); err != nil {
+		slog.Error("Failed to publish checkbox batch", "error", err)
+		_ = sse.ConsoleError(err)
+		return
+	}
+	// The browser has sent its changes, so start collecting the next batch afresh.
+	if err := sse.MarshalAndPatchSignals(batchSignals{Changes: []Message{}}); err != nil {
+		slog.Error("Error occurred when patching signals", "error", err)
+	}
 }
 
-func (h *Handler) listen(w http.ResponseWriter, r *http.Request) {
+// prepare validates updates sent by a client and stamps them with who sent them and when. The session is only
+// recorded as its audit id, as the updates are shared with every replica and kept in the public history.
+// Only the coordinates, value and an optional expected version are taken from the client.
+func (rm *room) prepare(r *http.Request, sessionID string, msgs []Message) error {
+	if len(msgs) > maxBatchSize {
+		return fmt.Errorf("batch of %d changes exceeds the limit of %d", len(msgs), maxBatchSize)
+	}
+	if err := rm.validate(msgs); err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range msgs {
+		msgs[i].Seq = 0
+		msgs[i].Version = 0
+		msgs[i].RequestID = requestID(r)
+		msgs[i].Time = now
+		msgs[i].Session = auditID(rm.auditKey, sessionID)
+	}
+	return nil
+}
+
+// requestID returns the id the request was tagged with by the server's middleware.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(shared.ContextRequestIDHeader).(string)
+	return id
+}
+
+// validate checks every update falls on the board.
+func (rm *room) validate(msgs []Message) error {
+	for _, msg := range msgs {
+		if msg.X >= rm.checkboxes.Width() || msg.Y >= rm.checkboxes.Height() {
+			return fmt.Errorf("coordinates out of bounds: x=%d, y=%d (max: %d,%d)",
+				msg.X, msg.Y, rm.checkboxes.Width()-1, rm.checkboxes.Height()-1)
+		}
+	}
+	return nil
+}
+
+func (rm *room) listen(w http.ResponseWriter, r *http.Request) {
 	requestId := r.Context().Value(shared.ContextRequestIDHeader)
 	slog.Debug("Checkbox listen()", "request_id", requestId)
+	view, err := rm.readViewport(r)
+	if err != nil {
+		slog.Warn("Ignoring malformed viewport", "request_id", requestId, "error", err)
+	}
+	sessionID := sessionCookie.Session(w, r)
 	sse := datastar.NewSSE(w, r)
+	members := rm.presence.Join(sse.Context(), sessionID)
 
-	err := sse.PatchElementTempl(CheckboxesFragment(&h.checkboxes, X_DIMENSION, Y_DIMENSION))
+	// Subscribe before reading the board so that no update can slip in between.
+	// Anything already covered by what resume() sends is skipped by its sequence id.
+	listener := rm.hub.SubscribeFunc(sse.Context(), view.Contains)
+	sent, err := rm.resume(sse, view, r.Header.Get("Last-Event-ID"))
 	if err != nil {
 		_ = sse.ConsoleError(err)
 		return
 	}
-	listener := make(chan Message)
-	h.addRx <- listener
-	slog.Debug("Checkbox listener connected", "request_id", requestId)
+	slog.Debug("Checkbox listener connected", "request_id", requestId, "listeners", rm.hub.Count())
+
+	// Updates are held for coalesceWindow after the first one arrives and then sent together,
+	// so dragging across the grid costs one event rather than one per checkbox.
+	pending := make([]Message, 0)
+	var flush <-chan time.Time
+
 	// Keep the context open until the connection closes (detectable via the request context)
+	// The hub removes the subscription itself once the context is done.
 	for {
 		select {
 		case <-sse.Context().Done():
 			slog.Debug("Checkbox listener disconnected", "request_id", requestId)
-			h.delRx <- listener
 			return
-		case msg := <-listener:
-			err := sse.PatchElementTempl(Checkbox(msg.X, msg.Y, msg.Value))
-			if err != nil {
+		case msg, ok := <-listener.C:
+			if !ok {
+				if sse.Context().Err() != nil {
+					return
+				}
+				// The hub evicted this listener for falling behind so resubscribe and resend the whole board.
+				slog.Warn("Checkbox listener fell behind, resynchronising", "request_id", requestId)
+				listener = rm.hub.SubscribeFunc(sse.Context(), view.Contains)
+				pending, flush = pending[:0], nil
+				if sent, err = rm.sendBoard(sse, view); err != nil {
+					slog.Error("Error occurred when patching", "error", err)
+				}
+				continue
+			}
+			if msg.Seq <= sent {
+				continue
+			}
+			pending = append(pending, msg)
+			sent = msg.Seq
+			if flush == nil {
+				flush = time.After(coalesceWindow)
+			}
+		case <-flush:
+			if err := rm.sendUpdates(sse, pending); err != nil {
 				slog.Error("Error occurred when patching", "error", err)
 			}
+			pending, flush = pending[:0], nil
+		case state, ok := <-members.C:
+			if !ok {
+				return
+			}
+			if err := presence.Patch(sse, state, sessionID, cellSize); err != nil {
+				slog.Error("Error occurred when patching", "error", err)
+			}
+		}
+	}
+}
+
+// resume brings a listener up to date and returns the last sequence id sent to it.
+// A browser reconnecting with a Last-Event-ID is replayed just the updates it missed when they are still
+// in the replay ring, and everyone else is sent the whole viewport.
+func (rm *room) resume(sse *datastar.ServerSentEventGenerator, view Viewport, lastEventID string) (uint64, error) {
+	seq, ok := rm.parseEventID(lastEventID)
+	if !ok {
+		return rm.sendBoard(sse, view)
+	}
+	missed, ok := rm.replay.Since(seq)
+	if !ok {
+		slog.Debug("Checkbox listener is too far behind to replay", "last_event_id", lastEventID)
+		return rm.sendBoard(sse, view)
+	}
+	slog.Debug("Replaying missed checkbox updates", "last_event_id", lastEventID, "count", len(missed))
+	visible := make([]Message, 0, len(missed))
+	for _, msg := range missed {
+		if view.Contains(msg) {
+			visible = append(visible, msg)
+		}
+	}
+	if len(missed) > 0 {
+		seq = missed[len(missed)-1].Seq
+	}
+	return seq, rm.sendUpdates(sse, visible)
+}
+
+// sendBoard sends every checkbox inside view.
+func (rm *room) sendBoard(sse *datastar.ServerSentEventGenerator, view Viewport) (uint64, error) {
+	window := rm.checkboxes.Window(view)
+	return window.Seq, sse.PatchElementTempl(
+		CheckboxesWindow(rm.basePath, window),
+		datastar.WithPatchElementsEventID(rm.eventID(window.Seq)),
+	)
+}
+
+// sendUpdates patches every updated checkbox in a single event identified by the last update's sequence id.
+// Only the final value of a checkbox that changed more than once is sent.
+func (rm *room) sendUpdates(sse *datastar.ServerSentEventGenerator, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	latest := make(map[[2]uint]int, len(msgs))
+	for i, msg := range msgs {
+		latest[[2]uint{msg.X, msg.Y}] = i
+	}
+
+	var buf strings.Builder
+	for i, msg := range msgs {
+		if latest[[2]uint{msg.X, msg.Y}] != i {
+			continue
+		}
+		cell := Cell{Value: msg.Value, Version: msg.Version}
+		if err := Checkbox(rm.basePath, msg.X, msg.Y, cell).Render(sse.Context(), &buf); err != nil {
+			return err
 		}
 	}
+	return sse.PatchElements(buf.String(), datastar.WithPatchElementsEventID(rm.eventID(msgs[len(msgs)-1].Seq)))
 }
@@ -0,0 +1,180 @@
+package checks
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"apparently-experiments/internal/broker"
+	"apparently-experiments/internal/hub"
+)
+
+// newTestRoom builds a room in memory without starting its workers, so tests can call apply as serve() would.
+func newTestRoom(t *testing.T, width, height uint) *room {
+	t.Helper()
+	history, err := openHistory("", "test", width, height, NewBitset(width*height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &room{
+		name:       "test",
+		basePath:   "/checks/test",
+		checkboxes: NewSyncMap(width, height),
+		hub:        hub.New[Message]("test", hub.Options{Buffer: listenerBuffer}),
+		broker:     broker.NewMemory(),
+		history:    history,
+		casTimeout: casTimeout,
+	}
+}
+
+func expect(version uint32) *uint32 {
+	return &version
+}
+
+func TestApplyCompareAndSet(t *testing.T) {
+	tests := []struct {
+		name string
+		msgs []Message
+		// accepted is the verdict reported for each message in turn.
+		accepted []bool
+		// versions are those of (0, 0) and (1, 0) afterwards, which start at 2 and 0.
+		versions [2]uint32
+	}{
+		{
+			name:     "current version",
+			msgs:     []Message{{X: 0, Value: true, Expected: expect(2)}},
+			accepted: []bool{true},
+			versions: [2]uint32{3, 0},
+		},
+		{
+			name:     "stale version",
+			msgs:     []Message{{X: 0, Value: true, Expected: expect(1)}},
+			accepted: []bool{false},
+			versions: [2]uint32{2, 0},
+		},
+		{
+			name:     "no version always applies",
+			msgs:     []Message{{X: 0, Value: true}},
+			accepted: []bool{true},
+			versions: [2]uint32{3, 0},
+		},
+		{
+			name:     "half stale, half current",
+			msgs:     []Message{{X: 0, Value: true, Expected: expect(1)}, {X: 1, Value: true, Expected: expect(0)}},
+			accepted: []bool{false, true},
+			versions: [2]uint32{2, 1},
+		},
+		{
+			name:     "same checkbox twice, each after the last",
+			msgs:     []Message{{X: 1, Value: true, Expected: expect(0)}, {X: 1, Value: false, Expected: expect(1)}},
+			accepted: []bool{true, true},
+			versions: [2]uint32{2, 2},
+		},
+		{
+			name:     "same checkbox twice from the same version",
+			msgs:     []Message{{X: 1, Value: true, Expected: expect(0)}, {X: 1, Value: false, Expected: expect(0)}},
+			accepted: []bool{true, false},
+			versions: [2]uint32{2, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rm := newTestRoom(t, 4, 4)
+			rm.checkboxes.Set(0, 0, true)
+			rm.checkboxes.Set(0, 0, false)
+			listener := rm.hub.Subscribe(t.Context())
+
+			results := make([]chan bool, len(tt.msgs))
+			for i := range tt.msgs {
+				tt.msgs[i].RequestID = fmt.Sprint("request-", i)
+				results[i] = make(chan bool, 1)
+				rm.pending.Store(tt.msgs[i].RequestID, results[i])
+			}
+			rm.apply(tt.msgs)
+
+			applied := 0
+			for i, result := range results {
+				select {
+				case accepted := <-result:
+					if accepted != tt.accepted[i] {
+						t.Errorf("update %v accepted = %v, want %v", i, accepted, tt.accepted[i])
+					}
+					if accepted {
+						applied++
+					}
+				default:
+					t.Errorf("no verdict reported for update %v", i)
+				}
+			}
+			for x, want := range tt.versions {
+				if got := rm.checkboxes.Cell(uint(x), 0).Version; got != want {
+					t.Errorf("(%v, 0) is at version %v, want %v", x, got, want)
+				}
+			}
+			// Only what was accepted reaches the listeners, and the last update to each checkbox carries its final version.
+			latest := make(map[uint]uint32)
+			for range applied {
+				msg := <-listener.C
+				latest[msg.X] = msg.Version
+			}
+			for x, version := range latest {
+				if version != tt.versions[x] {
+					t.Errorf("listeners were last sent version %v of (%v, 0), want %v", version, x, tt.versions[x])
+				}
+			}
+			select {
+			case msg := <-listener.C:
+				t.Errorf("listeners were sent a rejected update %+v", msg)
+			default:
+			}
+		})
+	}
+}
+
+func TestSubmitTimesOut(t *testing.T) {
+	// Nothing is subscribed to the room's topic, so the update is never applied and no verdict arrives.
+	rm := newTestRoom(t, 4, 4)
+	rm.casTimeout = 20 * time.Millisecond
+	accepted, err := rm.submit(t.Context(), Message{X: 1, Y: 1, Value: true, Expected: expect(0)})
+	if err != nil || accepted {
+		t.Fatalf("submit without a verdict returned %v, %v, want a rejection", accepted, err)
+	}
+	rm.pending.Range(func(id, _ any) bool {
+		t.Errorf("request %v is still waiting after timing out", id)
+		return true
+	})
+}
+
+// TestSubmitRacesThroughTheBroker sends the same compare-and-set from several clients at once to a running room.
+func TestSubmitRacesThroughTheBroker(t *testing.T) {
+	rm, err := newRoom("checks-test", "/checks/test", 4, 4, broker.NewMemory(), "", []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(rm.close)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for range 8 {
+		wg.Go(func() {
+			ok, err := rm.submit(t.Context(), Message{X: 2, Y: 3, Value: true, Expected: expect(0)})
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("%v of the racing updates were accepted, want exactly 1", accepted)
+	}
+	if cell := rm.checkboxes.Cell(2, 3); cell != (Cell{Value: true, Version: 1}) {
+		t.Errorf("the checkbox ended up as %+v, want checked at version 1", cell)
+	}
+}
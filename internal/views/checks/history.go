@@ -0,0 +1,483 @@
+package checks
+
+import (
+	"apparently-experiments/internal/shared"
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+const historyExtension = ".history"
+
+// When the history file is rewritten the previous one is kept alongside it with this extension, so the changes folded
+// out of the history are still on disk for one more rotation.
+const archiveExtension = ".1"
+
+// The most changes kept in memory for each board. Older changes are folded into the board the history starts from,
+// so the scrubber can reach back this far. The history file holds the same changes, so it is read back whole on startup.
+// Every open room may hold this many, so it is kept low enough that maxRooms of them fit in a couple of hundred megabytes.
+const maxHistory = 20_000
+
+// A copy of the board is kept every checkpointInterval changes, so rebuilding the board at any time replays at most
+// this many changes.
+const checkpointInterval = 1000
+
+// Change is a single recorded checkbox change in the audit history.
+type Change struct {
+	Time  time.Time `json:"time"`
+	X     uint      `json:"x"`
+	Y     uint      `json:"y"`
+	Value bool      `json:"value"`
+	// Session is the audit id of whoever made the change, a keyed hash of their session. It is empty for changes
+	// adopted from another replica's board.
+	Session   string `json:"session,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// auditID is what the history records in place of a browser's session. Being a keyed hash, it groups the changes made
+// by one browser without publishing the cookie that would let anyone act as it.
+func auditID(key []byte, session string) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(session))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// HistoryQuery selects the changes returned by history.Query. Zero fields match everything.
+type HistoryQuery struct {
+	From time.Time
+	To   time.Time
+	// Cell restricts the query to a single checkbox.
+	Cell  *[2]uint
+	Limit int
+}
+
+// checkpoint is a copy of the board once the first index changes of the history have been applied to its base.
+type checkpoint struct {
+	index  int
+	values Bitset
+}
+
+// historyLine is a line of the history file. The first line holds the board the history starts from, as packed by
+// Bitset.Bytes, and every line after it holds a change.
+type historyLine struct {
+	*Change
+	Base []byte `json:"base,omitempty"`
+}
+
+// history is the audit log of every change made to a board.
+// When a data directory is configured every change is also appended to a JSON lines file, which is read back on startup.
+type history struct {
+	mu     sync.RWMutex
+	width  uint
+	height uint
+	// base is the board before the first change in changes.
+	base    Bitset
+	changes []Change
+	// current is the board after every change in changes.
+	current Bitset
+	// checkpoints holds the board after every checkpointInterval changes, oldest first.
+	checkpoints []checkpoint
+	// path is the history file, and file is nil when the history only lives in memory.
+	path string
+	file *os.File
+	enc  *json.Encoder
+}
+
+// openHistory recovers the history of the board called name from dir. current is the board as recovered by its store,
+// which a history file that does not exist yet starts from.
+// With an empty dir the history starts from current and is only kept in memory.
+func openHistory(dir, name string, width, height uint, current Bitset) (*history, error) {
+	h := &history{width: width, height: height, base: current.Clone(), current: current.Clone()}
+	if dir == "" {
+		return h, nil
+	}
+
+	h.path = filepath.Join(dir, name+historyExtension)
+	file, err := os.Open(h.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, h.rewrite()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	err = h.read(file, name)
+	_ = file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	slog.Info("Recovered checkbox history", "board", name, "changes", len(h.changes))
+
+	if h.file, err = os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	h.enc = json.NewEncoder(h.file)
+	if len(h.changes) > maxHistory {
+		if err := h.fold(); err != nil {
+			_ = h.Close()
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// read loads the base board and changes from a history file.
+func (h *history) read(file *os.File, name string) error {
+	scanner := bufio.NewScanner(file)
+	// The base line holds the whole board, which for the largest boards is longer than a scanner's default limit.
+	scanner.Buffer(nil, 2*int(h.width*h.height/8+1)+bufio.MaxScanTokenSize)
+	for first := true; scanner.Scan(); first = false {
+		var line historyLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			// A torn final line is expected after a crash, as the history is not fsynced.
+			slog.Warn("Skipping unreadable history entry", "board", name, "error", err)
+			continue
+		}
+		if first && line.Base != nil {
+			base, err := BitsetFromBytes(h.width*h.height, line.Base)
+			if err != nil {
+				return err
+			}
+			h.base, h.current = base, base.Clone()
+			continue
+		}
+		if line.Change == nil || line.X >= h.width || line.Y >= h.height {
+			slog.Warn("Skipping unreadable history entry", "board", name)
+			continue
+		}
+		h.add(*line.Change)
+	}
+	return scanner.Err()
+}
+
+// Record appends changes to the history. Writing to the history file is best effort: the board's store is
+// what keeps the checkboxes durable, so a failed write is logged rather than rejecting the change.
+func (h *history) Record(changes ...Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, change := range changes {
+		// Replicas stamp the changes they receive with their own clocks, so the history is kept in order
+		// even if theirs disagree a little.
+		if n := len(h.changes); n > 0 && change.Time.Before(h.changes[n-1].Time) {
+			change.Time = h.changes[n-1].Time
+		}
+		h.add(change)
+		if h.enc != nil {
+			if err := h.enc.Encode(change); err != nil {
+				slog.Error("Failed to write checkbox history", "error", err)
+			}
+		}
+	}
+	if len(h.changes) > maxHistory {
+		if err := h.fold(); err != nil {
+			slog.Error("Failed to rotate checkbox history", "error", err)
+		}
+	}
+}
+
+// add applies a change to the current board, taking a checkpoint when one is due. It must be called with h.mu held.
+func (h *history) add(change Change) {
+	h.changes = append(h.changes, change)
+	h.current.Set(change.Y*h.width+change.X, change.Value)
+	if len(h.changes)%checkpointInterval == 0 {
+		h.checkpoints = append(h.checkpoints, checkpoint{index: len(h.changes), values: h.current.Clone()})
+	}
+}
+
+// fold drops the oldest changes once there are too many, starting the history from the checkpoint after them, and
+// rewrites the history file to match. It must be called with h.mu held.
+// A quarter of the history is folded at a time so the copy is not made on every change.
+func (h *history) fold() error {
+	keep := maxHistory * 3 / 4
+	i, _ := slices.BinarySearchFunc(h.checkpoints, len(h.changes)-keep, func(cp checkpoint, index int) int {
+		return cmp.Compare(cp.index, index)
+	})
+	if i == len(h.checkpoints) {
+		return nil
+	}
+	folded := h.checkpoints[i].index
+	h.base = h.checkpoints[i].values
+	h.changes = append([]Change(nil), h.changes[folded:]...)
+	checkpoints := make([]checkpoint, 0, len(h.checkpoints)-i-1)
+	for _, cp := range h.checkpoints[i+1:] {
+		checkpoints = append(checkpoints, checkpoint{index: cp.index - folded, values: cp.values})
+	}
+	h.checkpoints = checkpoints
+	if h.path == "" {
+		return nil
+	}
+	return h.rewrite()
+}
+
+// rewrite replaces the history file with one holding just the base board and the changes kept in memory, archiving the
+// file it replaces. The new file is written in full before it is renamed into place. It must be called with h.mu held,
+// or before the history is shared.
+func (h *history) rewrite() error {
+	if h.file != nil {
+		_ = h.file.Close()
+		h.file, h.enc = nil, nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if err := enc.Encode(historyLine{Base: h.base.Bytes()}); err != nil {
+		return err
+	}
+	for _, change := range h.changes {
+		if err := enc.Encode(change); err != nil {
+			return err
+		}
+	}
+	tmp := h.path + ".tmp"
+	if err := writeFileSync(tmp, buf.Bytes()); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	if err := os.Rename(h.path, h.path+archiveExtension); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("archive history: %w", err)
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return fmt.Errorf("install history: %w", err)
+	}
+	if err := syncDir(filepath.Dir(h.path)); err != nil {
+		return fmt.Errorf("sync data directory: %w", err)
+	}
+
+	file, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open history: %w", err)
+	}
+	h.file, h.enc = file, json.NewEncoder(file)
+	return nil
+}
+
+// Span returns the time of the first and last change held. Both are zero when there are none.
+func (h *history) Span() (time.Time, time.Time) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.changes) == 0 {
+		return time.Time{}, time.Time{}
+	}
+	return h.changes[0].Time, h.changes[len(h.changes)-1].Time
+}
+
+// Query returns the changes matching q in the order they were made, and whether any were left out by its limit.
+func (h *history) Query(q HistoryQuery) ([]Change, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	matched := make([]Change, 0)
+	for _, change := range h.changes {
+		if !q.From.IsZero() && change.Time.Before(q.From) {
+			continue
+		}
+		if !q.To.IsZero() && change.Time.After(q.To) {
+			break
+		}
+		if q.Cell != nil && (change.X != q.Cell[0] || change.Y != q.Cell[1]) {
+			continue
+		}
+		if q.Limit > 0 && len(matched) == q.Limit {
+			return matched, true
+		}
+		matched = append(matched, change)
+	}
+	return matched, false
+}
+
+// Window rebuilds the checkboxes inside view as they were at the given time, starting from the last checkpoint before it.
+// Only the value of each cell is recorded, so the cells in the returned window have no version.
+func (h *history) Window(view Viewport, at time.Time) *Window {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	// The changes up to n were made by the given time.
+	n := sort.Search(len(h.changes), func(i int) bool { return h.changes[i].Time.After(at) })
+	start, values := 0, &h.base
+	if i := sort.Search(len(h.checkpoints), func(i int) bool { return h.checkpoints[i].index > n }); i > 0 {
+		start, values = h.checkpoints[i-1].index, &h.checkpoints[i-1].values
+	}
+
+	window := &Window{View: view, cells: make([]Cell, view.Width*view.Height)}
+	for y := view.Y; y < view.Y+view.Height; y++ {
+		for x := view.X; x < view.X+view.Width; x++ {
+			window.cells[(y-view.Y)*view.Width+(x-view.X)].Value = values.Get(y*h.width + x)
+		}
+	}
+	for _, change := range h.changes[start:n] {
+		if view.Contains(Message{X: change.X, Y: change.Y}) {
+			window.cells[(change.Y-view.Y)*view.Width+(change.X-view.X)].Value = change.Value
+		}
+	}
+	return window
+}
+
+func (h *history) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	return h.file.Close()
+}
+
+const URI_PARAM_HISTORY string = "history"
+const URI_PARAM_FROM string = "from"
+const URI_PARAM_TO string = "to"
+const URI_PARAM_LIMIT string = "limit"
+
+// sessionCookie holds the anonymous id recorded against the changes made by a browser.
+var sessionCookie = shared.SessionCookie{Name: "checks_session", Path: "/checks"}
+
+// The most changes returned by one history query.
+const maxHistoryLimit = 10_000
+
+// Playing the history back steps through it in this many frames, one every playbackInterval.
+const playbackFrames = 200
+const playbackInterval = 50 * time.Millisecond
+
+type historySignals struct {
+	viewportSignals
+	// At is the time the scrubber is set to, in unix milliseconds.
+	At int64 `json:"at"`
+}
+
+// serveHistory serves the history page, the frames streamed to its scrubber and the JSON query endpoint,
+// selected by the value of the history parameter.
+func (rm *room) serveHistory(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get(URI_PARAM_HISTORY) {
+	case "frame":
+		rm.historyFrame(w, r)
+	case "play":
+		rm.historyPlay(w, r)
+	case "log":
+		rm.historyLog(w, r)
+	default:
+		x, _ := strconv.ParseUint(r.URL.Query().Get(URI_PARAM_X), 10, 0)
+		y, _ := strconv.ParseUint(r.URL.Query().Get(URI_PARAM_Y), 10, 0)
+		view := rm.viewport(uint(x), uint(y))
+		first, last := rm.history.Span()
+		if last.IsZero() {
+			first, last = time.Now(), time.Now()
+		}
+		templ.Handler(History(rm.basePath, first, last, rm.history.Window(view, last))).ServeHTTP(w, r)
+	}
+}
+
+// historyFrame sends the board as it was at the time the scrubber is set to.
+func (rm *room) historyFrame(w http.ResponseWriter, r *http.Request) {
+	signals := historySignals{}
+	err := datastar.ReadSignals(r, &signals)
+	sse := datastar.NewSSE(w, r)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	view := rm.viewport(signals.Viewport.X, signals.Viewport.Y)
+	if err := sse.PatchElementTempl(HistoryWindow(rm.history.Window(view, time.UnixMilli(signals.At)))); err != nil {
+		_ = sse.ConsoleError(err)
+	}
+}
+
+// historyPlay streams the board from the scrubber's position to the end of the history, moving the scrubber along with it.
+func (rm *room) historyPlay(w http.ResponseWriter, r *http.Request) {
+	signals := historySignals{}
+	err := datastar.ReadSignals(r, &signals)
+	sse := datastar.NewSSE(w, r)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	view := rm.viewport(signals.Viewport.X, signals.Viewport.Y)
+	first, last := rm.history.Span()
+	at := time.UnixMilli(signals.At)
+	if at.Before(first) || !at.Before(last) {
+		at = first
+	}
+	step := max(last.Sub(at)/playbackFrames, time.Millisecond)
+
+	ticker := time.NewTicker(playbackInterval)
+	defer ticker.Stop()
+	for {
+		if err := sse.PatchElementTempl(HistoryWindow(rm.history.Window(view, at))); err != nil {
+			slog.Debug("History playback stopped", "board", rm.name, "error", err)
+			return
+		}
+		if err := sse.MarshalAndPatchSignals(map[string]int64{"at": at.UnixMilli()}); err != nil {
+			slog.Debug("History playback stopped", "board", rm.name, "error", err)
+			return
+		}
+		if !at.Before(last) {
+			return
+		}
+		select {
+		case <-sse.Context().Done():
+			return
+		case <-ticker.C:
+			at = at.Add(step)
+			if at.After(last) {
+				at = last
+			}
+		}
+	}
+}
+
+// historyLog answers a JSON query of the history. from and to take RFC 3339 times, and x and y together select one checkbox.
+func (rm *room) historyLog(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := HistoryQuery{Limit: maxHistoryLimit}
+	var err error
+	if params.Has(URI_PARAM_FROM) {
+		if q.From, err = time.Parse(time.RFC3339, params.Get(URI_PARAM_FROM)); err != nil {
+			http.Error(w, fmt.Sprintf("invalid %v: %v", URI_PARAM_FROM, err), http.StatusBadRequest)
+			return
+		}
+	}
+	if params.Has(URI_PARAM_TO) {
+		if q.To, err = time.Parse(time.RFC3339, params.Get(URI_PARAM_TO)); err != nil {
+			http.Error(w, fmt.Sprintf("invalid %v: %v", URI_PARAM_TO, err), http.StatusBadRequest)
+			return
+		}
+	}
+	if params.Has(URI_PARAM_LIMIT) {
+		limit, err := strconv.Atoi(params.Get(URI_PARAM_LIMIT))
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			http.Error(w, fmt.Sprintf("%v must be between 1 and %v", URI_PARAM_LIMIT, maxHistoryLimit), http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
+	if params.Has(URI_PARAM_X) || params.Has(URI_PARAM_Y) {
+		x, errX := strconv.ParseUint(params.Get(URI_PARAM_X), 10, 0)
+		y, errY := strconv.ParseUint(params.Get(URI_PARAM_Y), 10, 0)
+		if errX != nil || errY != nil || rm.validate([]Message{{X: uint(x), Y: uint(y)}}) != nil {
+			http.Error(w, "x and y must both be given and fall on the board", http.StatusBadRequest)
+			return
+		}
+		q.Cell = &[2]uint{uint(x), uint(y)}
+	}
+
+	changes, more := rm.history.Query(q)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Changes []Change `json:"changes"`
+		More    bool     `json:"more"`
+	}{changes, more}); err != nil {
+		slog.Error("Failed to write checkbox history", "board", rm.name, "error", err)
+	}
+}
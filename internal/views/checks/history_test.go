@@ -0,0 +1,109 @@
+package checks
+
+import (
+	"bufio"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// replayed rebuilds the board at the given time by applying every change made by then, checkpoints aside.
+func replayed(changes []Change, width, height uint, at time.Time) Bitset {
+	values := NewBitset(width * height)
+	for _, change := range changes {
+		if change.Time.After(at) {
+			break
+		}
+		values.Set(change.Y*width+change.X, change.Value)
+	}
+	return values
+}
+
+func checkWindows(t *testing.T, h *history, all []Change, width, height uint) {
+	t.Helper()
+	first, last := h.Span()
+	view := Viewport{Width: width, Height: height}
+	for _, at := range []time.Time{first, first.Add(time.Millisecond * 1500), last.Add(-time.Millisecond * 999), last.Add(-time.Millisecond), last} {
+		want := replayed(all, width, height, at)
+		window := h.Window(view, at)
+		for y := range height {
+			for x := range width {
+				if window.Get(x, y).Value != want.Get(y*width+x) {
+					t.Fatalf("cell (%v, %v) at %v differs from replaying every change", x, y, at.Sub(first))
+				}
+			}
+		}
+	}
+}
+
+func TestHistoryFoldsAndRecovers(t *testing.T) {
+	const width, height = 10, 10
+	dir := t.TempDir()
+	h, err := openHistory(dir, "test", width, height, NewBitset(width*height))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	random := rand.New(rand.NewSource(1))
+	start := time.UnixMilli(0)
+	all := make([]Change, 0)
+	for i := range maxHistory*2 + 123 {
+		change := Change{
+			Time:  start.Add(time.Duration(i) * time.Millisecond),
+			X:     uint(random.Intn(width)),
+			Y:     uint(random.Intn(height)),
+			Value: random.Intn(2) == 0,
+		}
+		all = append(all, change)
+		h.Record(change)
+	}
+	if len(h.changes) > maxHistory {
+		t.Fatalf("history holds %v changes, want at most %v", len(h.changes), maxHistory)
+	}
+	checkWindows(t, h, all, width, height)
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := 0
+	file, err := os.Open(filepath.Join(dir, "test"+historyExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		lines++
+	}
+	if lines > maxHistory+1 {
+		t.Errorf("history file holds %v lines, want at most %v", lines, maxHistory+1)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test"+historyExtension+archiveExtension)); err != nil {
+		t.Errorf("expected the previous history file to be archived: %v", err)
+	}
+
+	recovered, err := openHistory(dir, "test", width, height, NewBitset(width*height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	if len(recovered.changes) != len(h.changes) {
+		t.Fatalf("recovered %v changes, want %v", len(recovered.changes), len(h.changes))
+	}
+	checkWindows(t, recovered, all, width, height)
+}
+
+func TestAuditIDHidesTheSession(t *testing.T) {
+	session := "3f1c4a2e-0000-4000-8000-000000000000"
+	id := auditID([]byte("key"), session)
+	if id == session || len(id) != 16 {
+		t.Errorf("audit id %q should be a short hash of the session", id)
+	}
+	if auditID([]byte("key"), session) != id {
+		t.Error("the same session should always get the same audit id")
+	}
+	if auditID([]byte("other key"), session) == id {
+		t.Error("a different key should give a different audit id")
+	}
+}
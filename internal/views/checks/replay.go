@@ -0,0 +1,64 @@
+package checks
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The number of recent updates kept for listeners resuming with a Last-Event-ID.
+// Anyone further behind than this is sent the whole board instead.
+const replaySize = 256
+
+// replayRing keeps the most recent updates indexed by their sequence id.
+type replayRing struct {
+	mu       sync.Mutex
+	messages [replaySize]Message
+	last     uint64
+}
+
+func (rr *replayRing) Add(msg Message) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.messages[msg.Seq%replaySize] = msg
+	rr.last = max(rr.last, msg.Seq)
+}
+
+// Since returns every update after seq in order.
+// It reports false when seq is unknown or some of the updates have already been overwritten.
+func (rr *replayRing) Since(seq uint64) ([]Message, bool) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if seq > rr.last || rr.last-seq > replaySize {
+		return nil, false
+	}
+	missed := make([]Message, 0, rr.last-seq)
+	for next := seq + 1; next <= rr.last; next++ {
+		msg := rr.messages[next%replaySize]
+		if msg.Seq != next {
+			return nil, false
+		}
+		missed = append(missed, msg)
+	}
+	return missed, true
+}
+
+// eventID formats a sequence id as an SSE event id.
+// Sequence ids restart with the process, so they are prefixed with its epoch to stop a browser
+// that reconnects after a restart from resuming at an unrelated position.
+func (rm *room) eventID(seq uint64) string {
+	return rm.epoch + ":" + strconv.FormatUint(seq, 10)
+}
+
+// parseEventID reverses eventID, reporting false for ids from another process or malformed ids.
+func (rm *room) parseEventID(id string) (uint64, bool) {
+	epoch, seq, found := strings.Cut(id, ":")
+	if !found || epoch != rm.epoch {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
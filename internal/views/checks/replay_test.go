@@ -0,0 +1,124 @@
+package checks
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+// filledRing holds updates with sequence ids 1 to n.
+func filledRing(n uint64) *replayRing {
+	rr := &replayRing{}
+	for seq := uint64(1); seq <= n; seq++ {
+		rr.Add(Message{Seq: seq, X: uint(seq % 8)})
+	}
+	return rr
+}
+
+func TestReplayRingSince(t *testing.T) {
+	tests := []struct {
+		name  string
+		added uint64
+		since uint64
+		// want is how many updates are replayed, counting up to the last one added.
+		want int
+		ok   bool
+	}{
+		{name: "empty ring", added: 0, since: 0, want: 0, ok: true},
+		{name: "a few behind", added: 10, since: 4, want: 6, ok: true},
+		{name: "up to date", added: 10, since: 10, want: 0, ok: true},
+		{name: "ahead of the ring", added: 10, since: 11, ok: false},
+		{name: "wrapped, oldest still kept", added: 300, since: 300 - replaySize, want: replaySize, ok: true},
+		{name: "wrapped, recent", added: 300, since: 290, want: 10, ok: true},
+		{name: "wrapped, oldest overwritten", added: 300, since: 300 - replaySize - 1, ok: false},
+		{name: "wrapped, from the start", added: 300, since: 0, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missed, ok := filledRing(tt.added).Since(tt.since)
+			if ok != tt.ok {
+				t.Fatalf("Since(%v) reported %v, want %v", tt.since, ok, tt.ok)
+			}
+			if len(missed) != tt.want {
+				t.Fatalf("Since(%v) replayed %v updates, want %v", tt.since, len(missed), tt.want)
+			}
+			for i, msg := range missed {
+				if msg.Seq != tt.since+uint64(i)+1 {
+					t.Fatalf("update %v has sequence id %v, want %v", i, msg.Seq, tt.since+uint64(i)+1)
+				}
+			}
+		})
+	}
+}
+
+func TestParseEventID(t *testing.T) {
+	rm := &room{epoch: "m1abc"}
+	tests := []struct {
+		id   string
+		want uint64
+		ok   bool
+	}{
+		{id: rm.eventID(42), want: 42, ok: true},
+		{id: "m1abc:0", want: 0, ok: true},
+		{id: "m0old:42", ok: false},
+		{id: "42", ok: false},
+		{id: "m1abc:", ok: false},
+		{id: "m1abc:-1", ok: false},
+		{id: "m1abc:4x", ok: false},
+		{id: "m1abc:18446744073709551616", ok: false},
+		{id: "", ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := rm.parseEventID(tt.id)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseEventID(%q) = %v, %v, want %v, %v", tt.id, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestResumeFallsBackToTheWindow checks which Last-Event-IDs are replayed and which are sent the whole viewport.
+func TestResumeFallsBackToTheWindow(t *testing.T) {
+	rm := &room{basePath: "/checks", epoch: "now", checkboxes: NewSyncMap(8, 8)}
+	for i := range uint(300) {
+		msg := Message{X: i % 8, Y: i / 8 % 8, Value: i%3 == 0}
+		msg.Seq, msg.Version = rm.checkboxes.Set(msg.X, msg.Y, msg.Value)
+		rm.replay.Add(msg)
+	}
+	view := Viewport{Width: 8, Height: 8}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		window      bool
+	}{
+		{name: "no id", lastEventID: "", window: true},
+		{name: "recent id", lastEventID: "now:290", window: false},
+		{name: "up to date", lastEventID: "now:300", window: false},
+		{name: "older than the ring", lastEventID: "now:10", window: true},
+		{name: "ahead of the ring", lastEventID: "now:400", window: true},
+		{name: "earlier process", lastEventID: "then:290", window: true},
+		{name: "malformed id", lastEventID: "now-290", window: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			sse := datastar.NewSSE(w, httptest.NewRequest("GET", "/checks", nil))
+			sent, err := rm.resume(sse, view, tt.lastEventID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sent != 300 {
+				t.Errorf("resume reports %v as sent, want 300", sent)
+			}
+			body := w.Body.String()
+			if window := strings.Contains(body, "checkboxes-window"); window != tt.window {
+				t.Errorf("sent the whole window = %v, want %v", window, tt.window)
+			}
+			if !tt.window && tt.lastEventID != "now:300" && !strings.Contains(body, "id: now:300") {
+				t.Errorf("replayed updates without the last event id:\n%v", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package checks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// replicaMessage is the envelope exchanged with other replicas through the broker.
+// Exactly one of Updates, SyncRequest or Snapshot is set.
+type replicaMessage struct {
+	Origin string `json:"origin"`
+	// Updates holds a single click or a whole batch, which every replica applies together.
+	Updates []Message `json:"updates,omitempty"`
+	// SyncRequest is sent once on startup to ask the existing replicas for their board.
+	SyncRequest bool `json:"syncRequest,omitempty"`
+	// Snapshot is the packed grid as returned by Bitset.Bytes, with Versions holding the version of every cell that has
+	// changed so compare-and-set updates reach the same verdict on every replica.
+	Snapshot []byte          `json:"snapshot,omitempty"`
+	Versions map[uint]uint32 `json:"versions,omitempty"`
+	// resync is set locally when the broker reports that messages may have been missed. It is never sent.
+	resync bool
+}
+
+func (rm *room) publish(ctx context.Context, msg replicaMessage) error {
+	msg.Origin = rm.origin
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return rm.broker.Publish(ctx, rm.name, payload)
+}
+
+// receive decodes messages from the broker and hands them to the serve() worker.
+// Updates from every replica, this one included, take the same path so all replicas apply them in the same order.
+func (rm *room) receive(updates <-chan []byte) {
+	for payload := range updates {
+		var msg replicaMessage
+		if payload == nil {
+			msg.resync = true
+		} else if err := json.Unmarshal(payload, &msg); err != nil {
+			slog.Error("Discarding malformed checks replica message", "error", err)
+			continue
+		}
+		if len(msg.Updates) > 0 {
+			if err := rm.validate(msg.Updates); err != nil {
+				slog.Error("Discarding invalid checks replica update", "board", rm.name, "error", err)
+				continue
+			}
+			select {
+			case rm.tx <- msg.Updates:
+			case <-rm.ctx.Done():
+			}
+			continue
+		}
+		if msg.Origin != rm.origin {
+			select {
+			case rm.sync <- msg:
+			case <-rm.ctx.Done():
+			}
+		}
+	}
+	if rm.ctx.Err() == nil {
+		slog.Warn("Checks broker subscription closed", "board", rm.name)
+	}
+}
+
+// handleSync answers other replicas' sync requests and adopts the first snapshot offered to this one.
+// After a gap in the broker's messages it asks for a snapshot again, as if the room had just been opened.
+// It must only be called from the serve() worker.
+func (rm *room) handleSync(msg replicaMessage) {
+	switch {
+	case msg.resync:
+		slog.Warn("Checks replica messages may have been missed, asking for the board again", "board", rm.name)
+		rm.synced = false
+		if err := rm.publish(rm.ctx, replicaMessage{SyncRequest: true}); err != nil {
+			slog.Error("Failed to request checks board from replicas", "board", rm.name, "error", err)
+		}
+
+	case msg.SyncRequest:
+		values, versions := rm.checkboxes.Snapshot()
+		if err := rm.publish(rm.ctx, replicaMessage{Snapshot: values.Bytes(), Versions: versions}); err != nil {
+			slog.Error("Failed to answer checks sync request", "board", rm.name, "error", err)
+		}
+
+	case msg.Snapshot != nil && !rm.synced:
+		width, height := rm.checkboxes.Width(), rm.checkboxes.Height()
+		values, err := BitsetFromBytes(width*height, msg.Snapshot)
+		for i := range msg.Versions {
+			if i >= width*height {
+				err = fmt.Errorf("snapshot has a version for cell %v, outside the %vx%v grid", i, width, height)
+			}
+		}
+		if err != nil {
+			slog.Error("Discarding checks snapshot from replica", "board", rm.name, "origin", msg.Origin, "error", err)
+			return
+		}
+		slog.Info("Adopting checks board from replica", "board", rm.name, "origin", msg.Origin)
+		rm.synced = true
+		// Only the differences are broadcast, and the adopted board is persisted as one snapshot
+		// rather than a log record per cell.
+		for y := range height {
+			for x := range width {
+				i := y*width + x
+				cell := Cell{Value: values.Get(i), Version: msg.Versions[i]}
+				if rm.checkboxes.Cell(x, y) != cell {
+					seq := rm.checkboxes.Restore(x, y, cell)
+					rm.history.Record(Change{Time: time.Now(), X: x, Y: y, Value: cell.Value})
+					rm.announce(Message{Seq: seq, X: x, Y: y, Value: cell.Value, Version: cell.Version})
+				}
+			}
+		}
+		if rm.store != nil {
+			rm.compact()
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package checks
+
+import (
+	"apparently-experiments/internal/broker"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const URI_PARAM_WIDTH string = "width"
+const URI_PARAM_HEIGHT string = "height"
+
+const (
+	// Rooms nobody has watched or used for this long are shut down. Persisted rooms are recovered on their next visit.
+	roomIdleTimeout     = 10 * time.Minute
+	roomCollectInterval = time.Minute
+	// Hard cap on the number of rooms held in memory at once.
+	maxRooms = 64
+	// Hard cap on the checkboxes across every room held in memory at once, pinned rooms included, so that a handful of
+	// million checkbox rooms cannot be opened alongside dozens of others.
+	maxOpenCells = 4 * MILLION_DIMENSION * MILLION_DIMENSION
+)
+
+var roomIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var errTooManyRooms = errors.New("too many checkbox rooms are open, please try again later")
+
+// roomConfig describes a room that always exists and is never collected.
+type roomConfig struct {
+	width  uint
+	height uint
+}
+
+// The original board is served from /checks itself, under the empty room id.
+var pinnedRooms = map[string]roomConfig{
+	"":        {width: X_DIMENSION, height: Y_DIMENSION},
+	"million": {width: MILLION_DIMENSION, height: MILLION_DIMENSION},
+}
+
+// Handler serves the checkbox rooms. /checks is the original shared board and /checks/{id} creates
+// a separate board on first visit, sized by the optional width and height query parameters.
+type Handler struct {
+	broker  broker.Broker
+	dataDir string
+	mu      sync.Mutex
+	rooms   map[string]*room
+	// opening holds a channel for each room being opened outside mu, which is closed once the attempt is over.
+	opening map[string]chan struct{}
+	// closing holds the rooms that have been collected but are still closing their files, which they must finish
+	// before the same id is opened again.
+	closing map[string]*room
+	// cells is the number of checkboxes across every open room.
+	cells uint
+	// auditKey keys the hash of a browser's session that is recorded in the history.
+	auditKey []byte
+}
+
+// NewHandler creates the checkbox demo. Updates are published through b so that every replica sharing it
+// shows the same boards. When dataDir is set each room is recovered from and persisted to it.
+// sessionKey keys the ids the history records for each browser, and should be shared by every replica so they agree.
+// Without one a random key is used, so the ids only stay the same until the process restarts.
+func NewHandler(b broker.Broker, dataDir string, sessionKey string) http.Handler {
+	h := &Handler{
+		broker:   b,
+		dataDir:  dataDir,
+		rooms:    make(map[string]*room),
+		opening:  make(map[string]chan struct{}),
+		closing:  make(map[string]*room),
+		auditKey: []byte(sessionKey),
+	}
+	if sessionKey == "" {
+		slog.Warn("SESSION_KEY is not set, so checkbox history ids will change when the server restarts")
+		h.auditKey = []byte(rand.Text())
+	}
+	for id, config := range pinnedRooms {
+		rm, err := newRoom(roomName(id), roomPath(id), config.width, config.height, b, dataDir, h.auditKey)
+		if err != nil {
+			panic(err)
+		}
+		h.rooms[id] = rm
+		h.cells += config.width * config.height
+	}
+	go h.collect()
+	return h
+}
+
+// roomName identifies a room in broker topics and persisted files.
+func roomName(id string) string {
+	if id == "" {
+		return "checks"
+	}
+	return "checks-" + id
+}
+
+func roomPath(id string) string {
+	if id == "" {
+		return "/checks"
+	}
+	return "/checks/" + id
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(URI_PARAM_ID)
+	if id != "" && !roomIDPattern.MatchString(id) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	rm, err := h.room(id, r)
+	if errors.Is(err, errTooManyRooms) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to open checkbox room", "room", id, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rm.ServeHTTP(w, r)
+}
+
+// room returns the room for id, creating it if this is its first visit.
+// Opening a room recovers its files, so it happens outside mu and requests for the same id wait for the first one.
+func (h *Handler) room(id string, r *http.Request) (*room, error) {
+	h.mu.Lock()
+	for {
+		if rm, ok := h.rooms[id]; ok {
+			defer h.mu.Unlock()
+			if err := checkDimensions(id, r, rm.checkboxes.Width(), rm.checkboxes.Height()); err != nil {
+				return nil, err
+			}
+			rm.touch()
+			return rm, nil
+		}
+		opening, ok := h.opening[id]
+		if !ok {
+			break
+		}
+		// Look again once the other request is done. If it failed, this one tries for itself.
+		h.mu.Unlock()
+		<-opening
+		h.mu.Lock()
+	}
+	if len(h.rooms)+len(h.opening) >= maxRooms {
+		h.mu.Unlock()
+		return nil, errTooManyRooms
+	}
+	opening := make(chan struct{})
+	h.opening[id] = opening
+	closing := h.closing[id]
+	h.mu.Unlock()
+
+	rm, err := h.open(id, r, closing)
+
+	h.mu.Lock()
+	delete(h.opening, id)
+	if err == nil {
+		h.rooms[id] = rm
+	}
+	h.mu.Unlock()
+	close(opening)
+	return rm, err
+}
+
+// open creates the room for id once closing, the room last collected under the same id if any, has finished closing.
+func (h *Handler) open(id string, r *http.Request, closing *room) (*room, error) {
+	if closing != nil {
+		closing.close()
+	}
+	width, height, err := h.dimensions(id, r)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	if h.cells+width*height > maxOpenCells {
+		h.mu.Unlock()
+		return nil, errTooManyRooms
+	}
+	h.cells += width * height
+	h.mu.Unlock()
+
+	rm, err := newRoom(roomName(id), roomPath(id), width, height, h.broker, h.dataDir, h.auditKey)
+	if err != nil {
+		h.mu.Lock()
+		h.cells -= width * height
+		h.mu.Unlock()
+		return nil, err
+	}
+	slog.Info("Opened checkbox room", "room", id, "width", width, "height", height)
+	return rm, nil
+}
+
+// dimensions works out the size of the room id is opening. A room that has been persisted keeps the size it was created
+// with, so the width and height parameters only size new rooms and asking for a different size is an error.
+func (h *Handler) dimensions(id string, r *http.Request) (uint, uint, error) {
+	width, err := dimension(r, URI_PARAM_WIDTH, X_DIMENSION)
+	if err != nil {
+		return 0, 0, err
+	}
+	height, err := dimension(r, URI_PARAM_HEIGHT, Y_DIMENSION)
+	if err != nil {
+		return 0, 0, err
+	}
+	if h.dataDir == "" {
+		return width, height, nil
+	}
+	manifest, found, err := readManifest(h.dataDir, roomName(id))
+	if err != nil || !found {
+		return width, height, err
+	}
+	return manifest.Width, manifest.Height, checkDimensions(id, r, manifest.Width, manifest.Height)
+}
+
+// checkDimensions rejects a width or height parameter that differs from the size room id already is.
+func checkDimensions(id string, r *http.Request, width, height uint) error {
+	query := r.URL.Query()
+	if (query.Has(URI_PARAM_WIDTH) && query.Get(URI_PARAM_WIDTH) != strconv.FormatUint(uint64(width), 10)) ||
+		(query.Has(URI_PARAM_HEIGHT) && query.Get(URI_PARAM_HEIGHT) != strconv.FormatUint(uint64(height), 10)) {
+		return fmt.Errorf("room %v is %vx%v", id, width, height)
+	}
+	return nil
+}
+
+// dimension reads an optional room dimension from the query string.
+func dimension(r *http.Request, param string, fallback uint) (uint, error) {
+	raw := r.URL.Query().Get(param)
+	if raw == "" {
+		return fallback, nil
+	}
+	value, err := strconv.ParseUint(raw, 10, 0)
+	if err != nil || value == 0 || uint(value) > MILLION_DIMENSION {
+		return 0, fmt.Errorf("%v must be between 1 and %v", param, MILLION_DIMENSION)
+	}
+	return uint(value), nil
+}
+
+// collect periodically shuts down rooms that have gone idle.
+func (h *Handler) collect() {
+	ticker := time.NewTicker(roomCollectInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.collectIdle(roomIdleTimeout)
+	}
+}
+
+// collectIdle shuts down every room that has been idle for timeout. They are closed outside mu, and stay in closing
+// until they are done so that nobody reopens them in the meantime.
+func (h *Handler) collectIdle(timeout time.Duration) {
+	h.mu.Lock()
+	idle := make(map[string]*room)
+	for id, rm := range h.rooms {
+		if _, pinned := pinnedRooms[id]; pinned || !rm.idle(timeout) {
+			continue
+		}
+		slog.Info("Closing idle checkbox room", "room", id)
+		idle[id] = rm
+		h.closing[id] = rm
+		delete(h.rooms, id)
+		h.cells -= rm.checkboxes.Width() * rm.checkboxes.Height()
+	}
+	h.mu.Unlock()
+
+	for id, rm := range idle {
+		rm.close()
+		h.mu.Lock()
+		if h.closing[id] == rm {
+			delete(h.closing, id)
+		}
+		h.mu.Unlock()
+	}
+}
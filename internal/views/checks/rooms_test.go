@@ -0,0 +1,103 @@
+package checks
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"apparently-experiments/internal/broker"
+)
+
+// newTestHandler serves rooms persisted to a temporary directory, without the pinned rooms or the collector.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	return &Handler{
+		broker:   broker.NewMemory(),
+		dataDir:  t.TempDir(),
+		rooms:    make(map[string]*room),
+		opening:  make(map[string]chan struct{}),
+		closing:  make(map[string]*room),
+		auditKey: []byte("test"),
+	}
+}
+
+// waitFor polls until done reports true, failing the test if it takes too long.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRoomOpensOnceAndReopensAfterClosing(t *testing.T) {
+	h := newTestHandler(t)
+	r := httptest.NewRequest("GET", "/checks/a?width=4&height=4", nil)
+
+	// Requests racing to open the same room all get the one room, counted once.
+	rooms := make([]*room, 8)
+	var wg sync.WaitGroup
+	for i := range rooms {
+		wg.Go(func() {
+			rm, err := h.room("a", r)
+			if err != nil {
+				t.Error(err)
+			}
+			rooms[i] = rm
+		})
+	}
+	wg.Wait()
+	for _, rm := range rooms[1:] {
+		if rm != rooms[0] {
+			t.Fatal("concurrent requests opened more than one room for the same id")
+		}
+	}
+	if h.cells != 16 || len(h.opening) != 0 {
+		t.Fatalf("%v cells and %v rooms opening after the requests, want 16 and 0", h.cells, len(h.opening))
+	}
+
+	rm := rooms[0]
+	rm.tx <- []Message{{X: 1, Y: 2, Value: true}}
+	waitFor(t, "the update to be applied", func() bool { return rm.checkboxes.Get(1, 2) })
+
+	// Collecting waits for the room to close its files, so it can be recovered straight away.
+	h.collectIdle(0)
+	if len(h.rooms) != 0 || len(h.closing) != 0 || h.cells != 0 {
+		t.Fatalf("%v rooms, %v closing and %v cells left after collecting", len(h.rooms), len(h.closing), h.cells)
+	}
+	select {
+	case <-rm.done:
+	default:
+		t.Fatal("the collected room was still serving")
+	}
+	reopened, err := h.room("a", httptest.NewRequest("GET", "/checks/a", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(reopened.close)
+	if reopened == rm {
+		t.Fatal("the collected room was handed out again")
+	}
+	if reopened.checkboxes.Width() != 4 || !reopened.checkboxes.Get(1, 2) {
+		t.Error("the reopened room lost its size or its checkbox")
+	}
+}
+
+func TestRoomFailingToOpenIsNotKept(t *testing.T) {
+	h := newTestHandler(t)
+	if _, err := h.room("a", httptest.NewRequest("GET", "/checks/a?width=0", nil)); err == nil {
+		t.Fatal("expected an error for a zero width")
+	}
+	if len(h.rooms) != 0 || len(h.opening) != 0 || h.cells != 0 {
+		t.Fatalf("%v rooms, %v opening and %v cells left after a failed open", len(h.rooms), len(h.opening), h.cells)
+	}
+	rm, err := h.room("a", httptest.NewRequest("GET", "/checks/a", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(rm.close)
+}
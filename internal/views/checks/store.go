@@ -0,0 +1,359 @@
+package checks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const (
+	snapshotExtension = ".snapshot"
+	walExtension      = ".wal"
+	manifestExtension = ".room"
+	// The write-ahead log is folded into a fresh snapshot once it holds this many records.
+	compactThreshold = 1024
+	// Each batch is written as one frame: the length of its records and a crc32 of them, followed by the records.
+	walFrameHeader = 4 + 4
+	// x, y, value and the cell's version once the update is applied.
+	walRecordSize = 4 + 4 + 1 + 4
+)
+
+var snapshotMagic = []byte("CHK2")
+
+// store persists the checkbox grid as a snapshot plus an append-only write-ahead log.
+// Every batch is fsynced before it is applied, so a crash loses at most the batch in flight. A batch is written as a
+// single checksummed frame, so a torn frame at the end of the log is detected and discarded whole on recovery.
+type store struct {
+	dir     string
+	name    string
+	width   uint
+	height  uint
+	wal     *os.File
+	records int
+	// size is the length of the log up to the end of its last whole frame.
+	size int64
+	// failed is set once the log can no longer be trusted to hold what was written to it, after which every append fails.
+	failed error
+}
+
+// openStore recovers the grid called name from dir, creating the directory if needed, and opens its log for appending.
+// The versions returned hold only the cells that have changed, keyed by their index in the grid.
+func openStore(dir, name string, width, height uint) (*store, Bitset, map[uint]uint32, error) {
+	s := &store{dir: dir, name: name, width: width, height: height}
+	values := NewBitset(width * height)
+	versions := make(map[uint]uint32)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, values, versions, fmt.Errorf("create data directory: %w", err)
+	}
+
+	manifest, found, err := readManifest(dir, name)
+	if err != nil {
+		return nil, values, versions, err
+	}
+	if found && (manifest.Width != width || manifest.Height != height) {
+		return nil, values, versions, fmt.Errorf("%v was created %vx%v and cannot be opened %vx%v", name, manifest.Width, manifest.Height, width, height)
+	}
+
+	if err := s.readSnapshot(&values, versions); err != nil {
+		return nil, values, versions, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, name+walExtension), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, values, versions, fmt.Errorf("open write-ahead log: %w", err)
+	}
+	s.wal = wal
+	if err := s.replayWAL(&values, versions); err != nil {
+		_ = wal.Close()
+		return nil, values, versions, err
+	}
+	// The manifest is only written once the grid has been recovered at this size, so a board saved before manifests
+	// existed is never recorded with the wrong one.
+	if !found {
+		if err := writeManifest(dir, name, roomManifest{Width: width, Height: height}); err != nil {
+			_ = wal.Close()
+			return nil, values, versions, err
+		}
+	}
+	slog.Info("Recovered checkbox grid", "dir", dir, "board", name, "wal_records", s.records)
+
+	return s, values, versions, nil
+}
+
+// Append durably records a batch of updates, each carrying the version its cell is at once applied, before they are
+// applied. When it fails none of the batch may be applied.
+func (s *store) Append(msgs ...Message) error {
+	if s.failed != nil {
+		return fmt.Errorf("write-ahead log is unusable: %w", s.failed)
+	}
+	frame := make([]byte, walFrameHeader+walRecordSize*len(msgs))
+	records := frame[walFrameHeader:]
+	for i, msg := range msgs {
+		record := records[i*walRecordSize : (i+1)*walRecordSize]
+		binary.LittleEndian.PutUint32(record[0:], uint32(msg.X))
+		binary.LittleEndian.PutUint32(record[4:], uint32(msg.Y))
+		if msg.Value {
+			record[8] = 1
+		}
+		binary.LittleEndian.PutUint32(record[9:], msg.Version)
+	}
+	binary.LittleEndian.PutUint32(frame[0:], uint32(len(records)))
+	binary.LittleEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(records))
+
+	if _, err := s.wal.Write(frame); err != nil {
+		// A short write leaves part of a frame behind, which would hide every frame written after it on recovery.
+		if rollbackErr := s.rollback(); rollbackErr != nil {
+			s.failed = rollbackErr
+		}
+		return fmt.Errorf("append to write-ahead log: %w", err)
+	}
+	if err := s.wal.Sync(); err != nil {
+		// The kernel may have dropped the pages that failed to sync, so the log cannot be trusted from here on.
+		s.failed = err
+		return fmt.Errorf("sync write-ahead log: %w", err)
+	}
+	s.size += int64(len(frame))
+	s.records += len(msgs)
+	return nil
+}
+
+// rollback cuts the log back to the end of its last whole frame.
+func (s *store) rollback() error {
+	if err := s.wal.Truncate(s.size); err != nil {
+		return fmt.Errorf("roll back write-ahead log: %w", err)
+	}
+	if _, err := s.wal.Seek(s.size, io.SeekStart); err != nil {
+		return fmt.Errorf("roll back write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// NeedsCompaction reports whether the log has grown enough to be folded into a snapshot.
+func (s *store) NeedsCompaction() bool {
+	return s.records >= compactThreshold
+}
+
+// Compact writes values and the versions of every changed cell as the new snapshot and empties the log.
+// The snapshot is renamed into place only once it is fully on disk, and the log is only truncated after that,
+// so a crash at any point leaves either the old snapshot and log or the new snapshot with a log that
+// replays harmlessly on top of it.
+func (s *store) Compact(values *Bitset, versions map[uint]uint32) error {
+	if s.failed != nil {
+		return fmt.Errorf("write-ahead log is unusable: %w", s.failed)
+	}
+	var buf bytes.Buffer
+	buf.Write(snapshotMagic)
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(s.width))
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(s.height))
+	buf.Write(values.Bytes())
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(versions)))
+	for _, i := range slices.Sorted(maps.Keys(versions)) {
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(i))
+		_ = binary.Write(&buf, binary.LittleEndian, versions[i])
+	}
+	_ = binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	path := filepath.Join(s.dir, s.name+snapshotExtension)
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, buf.Bytes()); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("install snapshot: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return fmt.Errorf("sync data directory: %w", err)
+	}
+
+	if err := s.wal.Truncate(0); err != nil {
+		return fmt.Errorf("truncate write-ahead log: %w", err)
+	}
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind write-ahead log: %w", err)
+	}
+	if err := s.wal.Sync(); err != nil {
+		return fmt.Errorf("sync write-ahead log: %w", err)
+	}
+	s.records = 0
+	s.size = 0
+	return nil
+}
+
+func (s *store) Close() error {
+	return s.wal.Close()
+}
+
+func (s *store) readSnapshot(values *Bitset, versions map[uint]uint32) error {
+	path := filepath.Join(s.dir, s.name+snapshotExtension)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	header := len(snapshotMagic) + 8
+	if len(data) < header+4 || !bytes.Equal(data[:len(snapshotMagic)], snapshotMagic) {
+		return fmt.Errorf("snapshot %v is not a checkbox snapshot", path)
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return fmt.Errorf("snapshot %v is corrupt", path)
+	}
+	width := binary.LittleEndian.Uint32(data[len(snapshotMagic):])
+	height := binary.LittleEndian.Uint32(data[len(snapshotMagic)+4:])
+	if uint(width) != s.width || uint(height) != s.height {
+		return fmt.Errorf("snapshot %v is %vx%v but the grid is %vx%v", path, width, height, s.width, s.height)
+	}
+
+	cells := s.width * s.height
+	packed := int((cells + 7) / 8)
+	if len(body) < header+packed+4 {
+		return fmt.Errorf("snapshot %v is truncated", path)
+	}
+	if *values, err = BitsetFromBytes(cells, body[header:header+packed]); err != nil {
+		return err
+	}
+	table := body[header+packed:]
+	count := int(binary.LittleEndian.Uint32(table))
+	if len(table) != 4+8*count {
+		return fmt.Errorf("snapshot %v has a malformed version table", path)
+	}
+	for entry := table[4:]; len(entry) > 0; entry = entry[8:] {
+		i := uint(binary.LittleEndian.Uint32(entry))
+		if i >= cells {
+			return fmt.Errorf("snapshot %v has a version for cell %v outside the grid", path, i)
+		}
+		versions[i] = binary.LittleEndian.Uint32(entry[4:])
+	}
+	return nil
+}
+
+// replayWAL applies every frame in the log to values and versions. Only a torn frame at the very end, left by a crash
+// part way through an append, is discarded. Anything else that fails to read means the log does not belong to this grid
+// or has been damaged, and is reported rather than truncated so that no recorded update is thrown away.
+func (s *store) replayWAL(values *Bitset, versions map[uint]uint32) error {
+	data, err := io.ReadAll(s.wal)
+	if err != nil {
+		return fmt.Errorf("read write-ahead log: %w", err)
+	}
+
+	offset := 0
+	for offset+walFrameHeader <= len(data) {
+		length := int(binary.LittleEndian.Uint32(data[offset:]))
+		end := offset + walFrameHeader + length
+		if end > len(data) {
+			break
+		}
+		records := data[offset+walFrameHeader : end]
+		if crc32.ChecksumIEEE(records) != binary.LittleEndian.Uint32(data[offset+4:]) || length%walRecordSize != 0 {
+			if end == len(data) {
+				break
+			}
+			return fmt.Errorf("write-ahead log of %v is corrupt at byte %v", s.name, offset)
+		}
+		for record := records; len(record) > 0; record = record[walRecordSize:] {
+			x := uint(binary.LittleEndian.Uint32(record[0:]))
+			y := uint(binary.LittleEndian.Uint32(record[4:]))
+			if x >= s.width || y >= s.height {
+				return fmt.Errorf("write-ahead log of %v changes (%v, %v), outside the %vx%v grid", s.name, x, y, s.width, s.height)
+			}
+			values.Set(y*s.width+x, record[8] == 1)
+			versions[y*s.width+x] = binary.LittleEndian.Uint32(record[9:])
+			s.records++
+		}
+		offset = end
+	}
+
+	s.size = int64(offset)
+	if offset != len(data) {
+		slog.Warn("Discarding torn write-ahead log tail", "board", s.name, "valid_bytes", offset, "total_bytes", len(data))
+		if err := s.wal.Truncate(s.size); err != nil {
+			return fmt.Errorf("truncate write-ahead log: %w", err)
+		}
+		if err := s.wal.Sync(); err != nil {
+			return fmt.Errorf("sync write-ahead log: %w", err)
+		}
+	}
+	if _, err := s.wal.Seek(s.size, io.SeekStart); err != nil {
+		return fmt.Errorf("seek write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// roomManifest records the size a grid was created with, so that it is always reopened at that size.
+type roomManifest struct {
+	Width  uint `json:"width"`
+	Height uint `json:"height"`
+}
+
+// readManifest returns the size the grid called name was created with, reporting false if it has never been persisted.
+func readManifest(dir, name string) (roomManifest, bool, error) {
+	var manifest roomManifest
+	data, err := os.ReadFile(filepath.Join(dir, name+manifestExtension))
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, false, nil
+	}
+	if err != nil {
+		return manifest, false, fmt.Errorf("read room manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Width == 0 || manifest.Height == 0 {
+		return manifest, false, fmt.Errorf("room manifest of %v is malformed", name)
+	}
+	return manifest, true, nil
+}
+
+func writeManifest(dir, name string, manifest roomManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name+manifestExtension)
+	if err := writeFileSync(path+".tmp", data); err != nil {
+		return fmt.Errorf("write room manifest: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("install room manifest: %w", err)
+	}
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("sync data directory: %w", err)
+	}
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir makes a rename within dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
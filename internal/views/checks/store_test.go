@@ -0,0 +1,290 @@
+package checks
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testWidth, testHeight = 8, 4
+
+func mustOpenStore(t *testing.T, dir string) (*store, Bitset, map[uint]uint32) {
+	t.Helper()
+	s, values, versions, err := openStore(dir, "test", testWidth, testHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s, values, versions
+}
+
+func mustAppend(t *testing.T, s *store, msgs ...Message) {
+	t.Helper()
+	if err := s.Append(msgs...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func walPath(dir string) string {
+	return filepath.Join(dir, "test"+walExtension)
+}
+
+func walSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(walPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+// appendBytes writes straight to the log, as a crash part way through an append would leave it.
+func appendBytes(t *testing.T, dir string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(walPath(dir), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		// write persists the grid, closing the store it was given once it has finished.
+		write func(t *testing.T, dir string, s *store)
+		// want is the value and version of each cell that should be recovered. Every other cell is unchecked at version 0.
+		want map[[2]uint]Cell
+		// wantSize is the length of the log once recovered, or -1 to skip checking it.
+		wantSize int64
+	}{
+		{
+			name: "log only",
+			write: func(t *testing.T, dir string, s *store) {
+				mustAppend(t, s, Message{X: 1, Y: 0, Value: true, Version: 1})
+				mustAppend(t, s, Message{X: 2, Y: 3, Value: true, Version: 1}, Message{X: 1, Y: 0, Value: false, Version: 2})
+			},
+			want:     map[[2]uint]Cell{{1, 0}: {Value: false, Version: 2}, {2, 3}: {Value: true, Version: 1}},
+			wantSize: 2*walFrameHeader + 3*walRecordSize,
+		},
+		{
+			name: "snapshot and log",
+			write: func(t *testing.T, dir string, s *store) {
+				mustAppend(t, s, Message{X: 0, Y: 0, Value: true, Version: 7})
+				values := NewBitset(testWidth * testHeight)
+				values.Set(0, true)
+				values.Set(5, true)
+				if err := s.Compact(&values, map[uint]uint32{0: 7, 5: 3}); err != nil {
+					t.Fatal(err)
+				}
+				mustAppend(t, s, Message{X: 5, Y: 0, Value: false, Version: 4})
+			},
+			want:     map[[2]uint]Cell{{0, 0}: {Value: true, Version: 7}, {5, 0}: {Value: false, Version: 4}},
+			wantSize: walFrameHeader + walRecordSize,
+		},
+		{
+			name: "torn frame header",
+			write: func(t *testing.T, dir string, s *store) {
+				mustAppend(t, s, Message{X: 3, Y: 1, Value: true, Version: 1})
+				appendBytes(t, dir, []byte{walRecordSize, 0})
+			},
+			want:     map[[2]uint]Cell{{3, 1}: {Value: true, Version: 1}},
+			wantSize: walFrameHeader + walRecordSize,
+		},
+		{
+			name: "torn records",
+			write: func(t *testing.T, dir string, s *store) {
+				mustAppend(t, s, Message{X: 3, Y: 1, Value: true, Version: 1})
+				frame := make([]byte, walFrameHeader+2*walRecordSize)
+				binary.LittleEndian.PutUint32(frame, 2*walRecordSize)
+				appendBytes(t, dir, frame[:walFrameHeader+walRecordSize+3])
+			},
+			want:     map[[2]uint]Cell{{3, 1}: {Value: true, Version: 1}},
+			wantSize: walFrameHeader + walRecordSize,
+		},
+		{
+			name: "final frame fails its checksum",
+			write: func(t *testing.T, dir string, s *store) {
+				mustAppend(t, s, Message{X: 3, Y: 1, Value: true, Version: 1})
+				mustAppend(t, s, Message{X: 4, Y: 1, Value: true, Version: 1})
+				_ = s.Close()
+				data, err := os.ReadFile(walPath(dir))
+				if err != nil {
+					t.Fatal(err)
+				}
+				data[len(data)-1] ^= 0xff
+				if err := os.WriteFile(walPath(dir), data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     map[[2]uint]Cell{{3, 1}: {Value: true, Version: 1}},
+			wantSize: walFrameHeader + walRecordSize,
+		},
+		{
+			name: "snapshot written but log not yet truncated",
+			write: func(t *testing.T, dir string, s *store) {
+				mustAppend(t, s, Message{X: 6, Y: 2, Value: true, Version: 1})
+				_ = s.Close()
+				log, err := os.ReadFile(walPath(dir))
+				if err != nil {
+					t.Fatal(err)
+				}
+				s, _, _, err = openStore(dir, "test", testWidth, testHeight)
+				if err != nil {
+					t.Fatal(err)
+				}
+				values := NewBitset(testWidth * testHeight)
+				values.Set(2*testWidth+6, true)
+				if err := s.Compact(&values, map[uint]uint32{2*testWidth + 6: 1}); err != nil {
+					t.Fatal(err)
+				}
+				_ = s.Close()
+				if err := os.WriteFile(walPath(dir), log, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     map[[2]uint]Cell{{6, 2}: {Value: true, Version: 1}},
+			wantSize: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, _, _, err := openStore(dir, "test", testWidth, testHeight)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.write(t, dir, s)
+			_ = s.Close()
+
+			s, values, versions := mustOpenStore(t, dir)
+			for y := range uint(testHeight) {
+				for x := range uint(testWidth) {
+					got := Cell{Value: values.Get(y*testWidth + x), Version: versions[y*testWidth+x]}
+					if got != tt.want[[2]uint{x, y}] {
+						t.Errorf("cell (%v, %v) recovered as %+v, want %+v", x, y, got, tt.want[[2]uint{x, y}])
+					}
+				}
+			}
+			if tt.wantSize >= 0 {
+				if size := walSize(t, dir); size != tt.wantSize {
+					t.Errorf("log is %v bytes after recovery, want %v", size, tt.wantSize)
+				}
+			}
+
+			// Whatever was discarded, the next append lands on a frame boundary and is recovered too.
+			mustAppend(t, s, Message{X: 7, Y: 3, Value: true, Version: 9})
+			_ = s.Close()
+			_, values, versions = mustOpenStore(t, dir)
+			if !values.Get(3*testWidth+7) || versions[3*testWidth+7] != 9 {
+				t.Error("an append made after recovery was lost")
+			}
+		})
+	}
+}
+
+func TestStoreRefusesDamagedLog(t *testing.T) {
+	tests := []struct {
+		name string
+		// width is the width of the grid the log is written for, which is reopened testWidth wide.
+		width  uint
+		damage func(data []byte) []byte
+	}{
+		{
+			name:  "corrupt frame before the end",
+			width: testWidth,
+			damage: func(data []byte) []byte {
+				data[walFrameHeader] ^= 0xff
+				return data
+			},
+		},
+		{
+			name:  "change outside the grid",
+			width: testWidth * 2,
+			damage: func(data []byte) []byte {
+				return data
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, _, _, err := openStore(dir, "test", tt.width, testHeight)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustAppend(t, s, Message{X: tt.width - 1, Y: 0, Value: true, Version: 1})
+			mustAppend(t, s, Message{X: 0, Y: 0, Value: true, Version: 1})
+			_ = s.Close()
+			data, err := os.ReadFile(walPath(dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(walPath(dir), tt.damage(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			// Without its manifest the log is read as if it were saved before manifests existed.
+			if err := os.Remove(filepath.Join(dir, "test"+manifestExtension)); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, _, _, err := openStore(dir, "test", testWidth, testHeight); err == nil {
+				t.Fatal("expected recovery to fail")
+			}
+			if size := walSize(t, dir); size != int64(len(data)) {
+				t.Errorf("log was cut to %v bytes, want it left at %v", size, len(data))
+			}
+		})
+	}
+}
+
+func TestStoreStopsAfterFailedAppend(t *testing.T) {
+	dir := t.TempDir()
+	s, _, _ := mustOpenStore(t, dir)
+	mustAppend(t, s, Message{X: 1, Y: 1, Value: true, Version: 1})
+
+	// A read-only handle fails the write and the rollback, leaving the log in an unknown state.
+	writable := s.wal
+	readOnly, err := os.Open(walPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.wal = readOnly
+	if err := s.Append(Message{X: 2, Y: 1, Value: true, Version: 1}); err == nil {
+		t.Fatal("expected the append to fail")
+	}
+	s.wal = writable
+	_ = readOnly.Close()
+	if err := s.Append(Message{X: 3, Y: 1, Value: true, Version: 1}); err == nil {
+		t.Fatal("expected appends to keep failing once the log could not be rolled back")
+	}
+	values := NewBitset(testWidth * testHeight)
+	if err := s.Compact(&values, nil); err == nil {
+		t.Fatal("expected compaction to fail once the log could not be rolled back")
+	}
+}
+
+func TestStoreKeepsTheSizeItWasCreatedWith(t *testing.T) {
+	dir := t.TempDir()
+	s, _, _ := mustOpenStore(t, dir)
+	mustAppend(t, s, Message{X: testWidth - 1, Y: testHeight - 1, Value: true, Version: 1})
+	_ = s.Close()
+	size := walSize(t, dir)
+
+	if _, _, _, err := openStore(dir, "test", testWidth/2, testHeight); err == nil {
+		t.Fatal("expected opening the grid at a different size to fail")
+	}
+	if got := walSize(t, dir); got != size {
+		t.Fatalf("log was cut to %v bytes, want it left at %v", got, size)
+	}
+	_, values, _ := mustOpenStore(t, dir)
+	if !values.Get(testWidth*testHeight - 1) {
+		t.Error("the grid lost its update after being opened at the wrong size")
+	}
+}
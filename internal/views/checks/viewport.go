@@ -0,0 +1,105 @@
+package checks
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+// The rendered size of each checkbox, used to turn scroll offsets into cell coordinates.
+const cellSize = 16
+
+// Viewport is the rectangle of the grid a listener is scrolled to. Listeners are only sent updates inside it.
+type Viewport struct {
+	X      uint
+	Y      uint
+	Width  uint
+	Height uint
+}
+
+func (v Viewport) Contains(msg Message) bool {
+	return msg.X >= v.X && msg.X < v.X+v.Width && msg.Y >= v.Y && msg.Y < v.Y+v.Height
+}
+
+// Window is a consistent copy of the checkboxes inside a viewport.
+type Window struct {
+	View Viewport
+	// Seq is the sequence id of the last update reflected in the window.
+	Seq   uint64
+	cells []Cell
+}
+
+// Get returns the checkbox at (x, y), which must lie inside the window's viewport.
+func (w *Window) Get(x, y uint) Cell {
+	return w.cells[(y-w.View.Y)*w.View.Width+(x-w.View.X)]
+}
+
+type viewportSignals struct {
+	Viewport struct {
+		X uint `json:"x"`
+		Y uint `json:"y"`
+	} `json:"viewport"`
+}
+
+// viewport returns the window of at most VIEWPORT_WIDTH by VIEWPORT_HEIGHT cells starting at (x, y),
+// shifted back as needed to stay on the board.
+func (rm *room) viewport(x, y uint) Viewport {
+	view := Viewport{
+		Width:  min(VIEWPORT_WIDTH, rm.checkboxes.Width()),
+		Height: min(VIEWPORT_HEIGHT, rm.checkboxes.Height()),
+	}
+	view.X = min(x, rm.checkboxes.Width()-view.Width)
+	view.Y = min(y, rm.checkboxes.Height()-view.Height)
+	return view
+}
+
+// readViewport reads the scroll position the browser sends along with its listen request.
+// The top left of the board is used when there is none.
+func (rm *room) readViewport(r *http.Request) (Viewport, error) {
+	signals := viewportSignals{}
+	if err := datastar.ReadSignals(r, &signals); err != nil {
+		return rm.viewport(0, 0), err
+	}
+	return rm.viewport(signals.Viewport.X, signals.Viewport.Y), nil
+}
+
+func containerStyle(view Viewport) string {
+	return fmt.Sprintf("width: %vpx; height: %vpx;", view.Width*cellSize, view.Height*cellSize)
+}
+
+func spacerStyle(state *SyncMap) string {
+	return fmt.Sprintf("width: %vpx; height: %vpx;", state.Width()*cellSize, state.Height()*cellSize)
+}
+
+func windowStyle(view Viewport) string {
+	return fmt.Sprintf("left: %vpx; top: %vpx; grid-template-columns: repeat(%v, %vpx);",
+		view.X*cellSize, view.Y*cellSize, view.Width, cellSize)
+}
+
+// scrollExpression records which cell is at the top left of the scrolled container and
+// reconnects the listener so it streams that viewport instead.
+func scrollExpression(basePath string) string {
+	return fmt.Sprintf(
+		"$viewport.x = Math.floor(el.scrollLeft / %v); $viewport.y = Math.floor(el.scrollTop / %v); @get('%v?listen=true', {openWhenHidden: true})",
+		cellSize, cellSize, basePath,
+	)
+}
+
+// Dragging across the grid paints every checkbox passed over with the opposite of the first one's value.
+// The painted cells are collected in the changes signal and posted as one batch when the pointer is released.
+// A plain click is left to the checkbox's own change handler.
+const dragStartExpression = "if (evt.target.type == 'checkbox') { $_dragging = true; $_paint = !evt.target.checked; " +
+	"$changes = [{x: +evt.target.id.split('-')[0], y: +evt.target.id.split('-')[1], value: $_paint}] }"
+
+const dragExpression = "if ($_dragging && evt.target.type == 'checkbox' && evt.target.checked != $_paint) { " +
+	"evt.target.checked = $_paint; " +
+	"$changes = [...$changes, {x: +evt.target.id.split('-')[0], y: +evt.target.id.split('-')[1], value: $_paint}] }"
+
+// The batch is posted from the same element as the listen stream, which datastar would otherwise abort.
+func dragEndExpression(basePath string) string {
+	return fmt.Sprintf(
+		"$_dragging = false; if ($changes.length > 1) { @post('%v?batch=true', {requestCancellation: 'disabled'}) } else { $changes = [] }",
+		basePath,
+	)
+}
@@ -0,0 +1,79 @@
+package gameoflife
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+const URI_PARAM_VIEW = "view"
+
+// viewCanvas draws the board into a canvas from a bit-packed copy sent as a signal, instead of patching a div per cell.
+const viewCanvas = "canvas"
+
+// CanvasBoard is the board as sent to canvas viewers: packed one bit per cell row by row, as in packBoard, then base64 encoded.
+type CanvasBoard struct {
+	Board string `json:"board"`
+	Seq   uint64 `json:"seq"`
+}
+
+type canvasSignals struct {
+	Gol CanvasBoard `json:"gol"`
+}
+
+// renderCanvas encodes the board as signals, shared by every canvas listener that needs it.
+func (f *Frame) renderCanvas() ([]byte, error) {
+	f.canvasOnce.Do(func() {
+		defer observeRender("canvas")()
+		board := base64.StdEncoding.EncodeToString(packBoard(&f.Board.board))
+		f.canvas, f.canvasErr = json.Marshal(canvasSignals{Gol: CanvasBoard{Board: board, Seq: f.Seq}})
+	})
+	return f.canvas, f.canvasErr
+}
+
+// sendCanvasFrame brings a canvas listener that last saw frame seq up to date with f. The packed board is small enough
+// that it is always sent whole.
+func sendCanvasFrame(sse *datastar.ServerSentEventGenerator, f *Frame, seq uint64) (uint64, error) {
+	if f.Seq <= seq {
+		return seq, nil
+	}
+	signals, err := f.renderCanvas()
+	if err != nil {
+		return seq, err
+	}
+	if err := sse.PatchSignals(signals); err != nil {
+		return seq, err
+	}
+	frameBytes.WithLabelValues("canvas").Add(float64(len(signals)))
+	return f.Seq, nil
+}
+
+// canvasSignalsJSON starts the canvas off with the board it was rendered with.
+func canvasSignalsJSON(f *Frame) string {
+	signals, err := f.renderCanvas()
+	if err != nil {
+		return "{}"
+	}
+	return string(signals)
+}
+
+// drawExpression redraws the canvas whenever the board signal changes, filling a square for each set bit.
+var drawExpression = fmt.Sprintf(
+	"const bits = Uint8Array.from(atob($gol.board), c => c.charCodeAt(0)); "+
+		"const ctx = el.getContext('2d'); "+
+		"ctx.clearRect(0, 0, el.width, el.height); "+
+		"ctx.fillStyle = getComputedStyle(el).color; "+
+		"Array.from({length: %[1]v}, (_, i) => i).forEach(i => (bits[i >> 3] & (1 << (i & 7))) && ctx.fillRect((i %% %[2]v) * %[3]v, Math.floor(i / %[2]v) * %[3]v, %[3]v - 1, %[3]v - 1))",
+	boardSizeX*boardSizeY, boardSizeX, cellSize)
+
+// canvasClickExpression posts the cell under the pointer, which the canvas view finds from the pointer's offset.
+func canvasClickExpression(basePath string) string {
+	return fmt.Sprintf("@post('%v?%v=%v&id=' + Math.floor(evt.offsetX / %v) + '-' + Math.floor(evt.offsetY / %v))",
+		basePath, URI_PARAM_VIEW, viewCanvas, cellSize, cellSize)
+}
+
+func canvasStyle() string {
+	return fmt.Sprintf("width: %vpx; height: %vpx;", boardSizeX*cellSize, boardSizeY*cellSize)
+}
@@ -0,0 +1,233 @@
+package gameoflife
+
+import (
+	"math/bits"
+	"runtime"
+	"sync"
+)
+
+const (
+	// Boards with fewer cells than this are stepped on a single goroutine. BenchmarkBitBoardStep has yet to find a
+	// machine where striping pays off at any size, so measure before lowering it.
+	minParallelCells = 256 * 256
+	// Each stripe gets at least this many rows.
+	minStripeRows = 32
+)
+
+// bitBoard stores a board of any size one bit per cell, each row as a run of uint64 words with cell x in bit x%64 of
+// word x/64. Bits past the width in a row's last word are always clear.
+type bitBoard struct {
+	width, height int
+	// words is the number of words in each row.
+	words int
+	cells []uint64
+}
+
+func newBitBoard(width, height int) *bitBoard {
+	words := (width + 63) / 64
+	return &bitBoard{width: width, height: height, words: words, cells: make([]uint64, words*height)}
+}
+
+func (b *bitBoard) row(y int) []uint64 {
+	return b.cells[y*b.words : (y+1)*b.words]
+}
+
+func (b *bitBoard) get(x, y int) bool {
+	return b.cells[y*b.words+x/64]&(1<<(x%64)) != 0
+}
+
+func (b *bitBoard) set(x, y int, alive bool) {
+	if alive {
+		b.cells[y*b.words+x/64] |= 1 << (x % 64)
+	} else {
+		b.cells[y*b.words+x/64] &^= 1 << (x % 64)
+	}
+}
+
+func (b *bitBoard) population() int {
+	alive := 0
+	for _, word := range b.cells {
+		alive += bits.OnesCount64(word)
+	}
+	return alive
+}
+
+// lastMask clears the bits past the width in a row's last word.
+func (b *bitBoard) lastMask() uint64 {
+	if b.width%64 == 0 {
+		return ^uint64(0)
+	}
+	return 1<<(b.width%64) - 1
+}
+
+// stripes picks how many goroutines step the board.
+func (b *bitBoard) stripes() int {
+	if b.width*b.height < minParallelCells {
+		return 1
+	}
+	return max(1, min(runtime.GOMAXPROCS(0), b.height/minStripeRows))
+}
+
+// step writes the generation after b into next, which must be the same size, splitting larger boards into horizontal
+// stripes that are stepped in parallel.
+func (b *bitBoard) step(next *bitBoard, rule Rule, topology Topology) {
+	stripes := b.stripes()
+	if stripes == 1 {
+		b.stepRows(next, rule, topology, 0, b.height)
+		return
+	}
+	var wg sync.WaitGroup
+	for i := range stripes {
+		wg.Go(func() {
+			b.stepRows(next, rule, topology, i*b.height/stripes, (i+1)*b.height/stripes)
+		})
+	}
+	wg.Wait()
+}
+
+// stepRows steps the rows from y0 up to y1. Stripes only write their own rows of next, so they never overlap.
+func (b *bitBoard) stepRows(next *bitBoard, rule Rule, topology Topology, y0, y1 int) {
+	wrapsX := topology != Bounded
+	wrapsY := topology == Torus || topology == KleinBottle
+	// Scratch rows for the three rows around each cell, each shifted so that the neighbour to the west or east lines up.
+	var rows [3][3][]uint64
+	for i := range rows {
+		for j := range rows[i] {
+			rows[i][j] = make([]uint64, b.words)
+		}
+	}
+	empty := make([]uint64, b.words)
+	lastMask := b.lastMask()
+
+	for y := y0; y < y1; y++ {
+		for i, dy := range []int{-1, 0, 1} {
+			ny := y + dy
+			mirrored := false
+			if ny < 0 || ny >= b.height {
+				if !wrapsY {
+					copy(rows[i][1], empty)
+					b.shift(rows[i], wrapsX)
+					continue
+				}
+				ny = (ny + b.height) % b.height
+				mirrored = topology == KleinBottle
+			}
+			if mirrored {
+				b.reverse(rows[i][1], b.row(ny))
+			} else {
+				copy(rows[i][1], b.row(ny))
+			}
+			b.shift(rows[i], wrapsX)
+		}
+
+		out := next.row(y)
+		alive := b.row(y)
+		for w := range b.words {
+			b0, b1, b2, b3 := countNeighbours(
+				rows[0][0][w], rows[0][1][w], rows[0][2][w],
+				rows[1][0][w], rows[1][2][w],
+				rows[2][0][w], rows[2][1][w], rows[2][2][w],
+			)
+			var born, survive uint64
+			for n := range 9 {
+				birth, survival := rule.Birth&(1<<n) != 0, rule.Survive&(1<<n) != 0
+				if !birth && !survival {
+					continue
+				}
+				match := pick(b0, n&1 != 0) & pick(b1, n&2 != 0) & pick(b2, n&4 != 0) & pick(b3, n&8 != 0)
+				if birth {
+					born |= match
+				}
+				if survival {
+					survive |= match
+				}
+			}
+			out[w] = alive[w]&survive | ^alive[w]&born
+		}
+		out[b.words-1] &= lastMask
+	}
+}
+
+// shift fills row[0] and row[2] from row[1] so that each cell lines up with its west and east neighbour respectively.
+// When wraps is set the neighbour past an edge is the cell at the opposite edge, otherwise it is dead.
+func (b *bitBoard) shift(row [3][]uint64, wraps bool) {
+	west, middle, east := row[0], row[1], row[2]
+	last := b.words - 1
+	for w := range b.words {
+		west[w] = middle[w] << 1
+		if w > 0 {
+			west[w] |= middle[w-1] >> 63
+		}
+		east[w] = middle[w] >> 1
+		if w < last {
+			east[w] |= middle[w+1] << 63
+		}
+	}
+	// The west shift carries the top cell into the padding, and the east shift leaves the top cell's neighbour clear.
+	west[last] &= b.lastMask()
+	if wraps {
+		top := (b.width - 1) % 64
+		west[0] |= middle[last] >> top & 1
+		east[last] |= (middle[0] & 1) << top
+	}
+}
+
+// reverse writes src into dst mirrored left to right, as a Klein bottle's rows are when crossing its top or bottom edge.
+func (b *bitBoard) reverse(dst, src []uint64) {
+	clear(dst)
+	for x := range b.width {
+		if src[x/64]&(1<<(x%64)) != 0 {
+			mirror := b.width - 1 - x
+			dst[mirror/64] |= 1 << (mirror % 64)
+		}
+	}
+}
+
+// countNeighbours adds up eight neighbour words bit by bit, returning each cell's count as four bits from least significant.
+func countNeighbours(n0, n1, n2, n3, n4, n5, n6, n7 uint64) (b0, b1, b2, b3 uint64) {
+	s0, c0 := fullAdd(n0, n1, n2)
+	s1, c1 := fullAdd(n3, n4, n5)
+	s2, c2 := n6^n7, n6&n7
+	// Ones from the three partial sums, with a carry into the twos.
+	b0, c3 := fullAdd(s0, s1, s2)
+	// Twos from the four carries, with carries into the fours.
+	t, c4 := fullAdd(c0, c1, c2)
+	b1, c5 := t^c3, t&c3
+	b2, b3 = c4^c5, c4&c5
+	return b0, b1, b2, b3
+}
+
+func fullAdd(a, b, c uint64) (sum, carry uint64) {
+	return a ^ b ^ c, a&b | c&(a^b)
+}
+
+// pick returns the bits that are set when want is true, and those that are clear otherwise.
+func pick(bits uint64, want bool) uint64 {
+	if want {
+		return bits
+	}
+	return ^bits
+}
+
+// packRows copies a board into a bitBoard.
+func packRows(board *[boardSizeX][boardSizeY]bool) *bitBoard {
+	b := newBitBoard(boardSizeX, boardSizeY)
+	for y := range boardSizeY {
+		for x := range boardSizeX {
+			if board[x][y] {
+				b.set(x, y, true)
+			}
+		}
+	}
+	return b
+}
+
+func (b *bitBoard) unpack() [boardSizeX][boardSizeY]bool {
+	board := [boardSizeX][boardSizeY]bool{}
+	for y := range boardSizeY {
+		for x := range boardSizeX {
+			board[x][y] = b.get(x, y)
+		}
+	}
+	return board
+}
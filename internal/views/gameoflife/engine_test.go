@@ -0,0 +1,173 @@
+package gameoflife
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// stepBounded steps a bitBoard one cell at a time, counting everything past its edges as dead.
+func stepBounded(b *bitBoard, rule Rule) *bitBoard {
+	next := newBitBoard(b.width, b.height)
+	for y := range b.height {
+		for x := range b.width {
+			neighbours := 0
+			for ny := max(y-1, 0); ny <= min(y+1, b.height-1); ny++ {
+				for nx := max(x-1, 0); nx <= min(x+1, b.width-1); nx++ {
+					if (nx != x || ny != y) && b.get(nx, ny) {
+						neighbours++
+					}
+				}
+			}
+			next.set(x, y, rule.Next(b.get(x, y), neighbours))
+		}
+	}
+	return next
+}
+
+// tiled lays out what surrounds a board under topology as a board three times its size, with the board itself in the
+// middle tile. Stepping that board without wrapping gives the board's next generation in its middle tile, without
+// relying on the engine's own idea of what lies past each edge. A torus repeats the board in every direction, a cylinder
+// only to the left and right, and a Klein bottle mirrors the copies above and below it left to right.
+func tiled(b *bitBoard, topology Topology) *bitBoard {
+	out := newBitBoard(b.width*3, b.height*3)
+	for ty := -1; ty <= 1; ty++ {
+		for tx := -1; tx <= 1; tx++ {
+			if topology == Bounded && (tx != 0 || ty != 0) || topology == Cylinder && ty != 0 {
+				continue
+			}
+			for y := range b.height {
+				for x := range b.width {
+					sx := x
+					if topology == KleinBottle && ty != 0 {
+						sx = b.width - 1 - x
+					}
+					out.set((tx+1)*b.width+x, (ty+1)*b.height+y, b.get(sx, y))
+				}
+			}
+		}
+	}
+	return out
+}
+
+// stepTiled is the next generation of b worked out from its tiled surroundings.
+func stepTiled(b *bitBoard, rule Rule, topology Topology) *bitBoard {
+	stepped := stepBounded(tiled(b, topology), rule)
+	next := newBitBoard(b.width, b.height)
+	for y := range b.height {
+		for x := range b.width {
+			next.set(x, y, stepped.get(b.width+x, b.height+y))
+		}
+	}
+	return next
+}
+
+func randomBitBoard(width, height int, seed int64) *bitBoard {
+	random := rand.New(rand.NewSource(seed))
+	b := newBitBoard(width, height)
+	for y := range height {
+		for x := range width {
+			b.set(x, y, random.Intn(3) == 0)
+		}
+	}
+	return b
+}
+
+// engineRules covers births and survivals at every count, including births with no neighbours at all.
+var engineRules = []string{"B3/S23", "B36/S23", "B2/S", "B3678/S34678", "B1357/S1357", "B0/S8", "B012345678/S012345678"}
+
+// TestStepMatchesNaive checks the bit-packed engine against stepping one cell at a time, for every topology.
+func TestStepMatchesNaive(t *testing.T) {
+	for _, r := range engineRules {
+		rule := mustParseRule(t, r)
+		for _, topology := range topologies {
+			t.Run(fmt.Sprintf("%v %v", r, topology), func(t *testing.T) {
+				board := randomBoard(7, 40)
+				for generation := range 20 {
+					want, wantAlive := stepNaive(&board, rule, topology)
+					got, alive := step(&board, rule, topology)
+					if got != want || alive != wantAlive {
+						t.Fatalf("generation %v differs from the naive step, %v live cells, want %v", generation, alive, wantAlive)
+					}
+					board = got
+				}
+			})
+		}
+	}
+}
+
+// TestBitBoardSizes checks boards whose rows do not fill their last word, span several words, or are split into stripes,
+// against stepping their tiled surroundings one cell at a time.
+func TestBitBoardSizes(t *testing.T) {
+	sizes := []struct{ width, height int }{{1, 1}, {3, 5}, {50, 50}, {63, 10}, {64, 64}, {65, 3}, {130, 70}, {300, 300}}
+	for _, size := range sizes {
+		for _, topology := range topologies {
+			t.Run(fmt.Sprintf("%vx%v %v", size.width, size.height, topology), func(t *testing.T) {
+				board := randomBitBoard(size.width, size.height, 11)
+				for generation := range 5 {
+					want := stepTiled(board, Conway, topology)
+					got := newBitBoard(size.width, size.height)
+					board.step(got, Conway, topology)
+					for y := range size.height {
+						for x := range size.width {
+							if got.get(x, y) != want.get(x, y) {
+								t.Fatalf("generation %v differs from stepping its tiled surroundings at (%v, %v)", generation, x, y)
+							}
+						}
+						if last := got.row(y)[got.words-1]; last&^got.lastMask() != 0 {
+							t.Fatalf("generation %v set bits past the width in row %v", generation, y)
+						}
+					}
+					board = got
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkStepNaive(b *testing.B) {
+	board := randomBoard(1, defaultDensity)
+	for b.Loop() {
+		board, _ = stepNaive(&board, Conway, Torus)
+	}
+}
+
+// BenchmarkStep includes packing the board into words and back, as the rooms do every generation. Compared with the
+// 50x50 case of BenchmarkBitBoardStep, the packing takes most of the time.
+func BenchmarkStep(b *testing.B) {
+	board := randomBoard(1, defaultDensity)
+	for b.Loop() {
+		board, _ = step(&board, Conway, Torus)
+	}
+}
+
+func BenchmarkBitBoardStep(b *testing.B) {
+	for _, size := range []int{50, 256, 1024, 4096} {
+		board := randomBitBoard(size, size, 1)
+		next := newBitBoard(size, size)
+		b.Run(fmt.Sprintf("%vx%v single", size, size), func(b *testing.B) {
+			for b.Loop() {
+				board.stepRows(next, Conway, Torus, 0, size)
+				board, next = next, board
+			}
+		})
+		b.Run(fmt.Sprintf("%vx%v striped", size, size), func(b *testing.B) {
+			for b.Loop() {
+				board.step(next, Conway, Torus)
+				board, next = next, board
+			}
+		})
+	}
+}
+
+// BenchmarkNaiveSized steps bounded boards one cell at a time, to compare with BenchmarkBitBoardStep.
+func BenchmarkNaiveSized(b *testing.B) {
+	for _, size := range []int{50, 256, 1024} {
+		board := randomBitBoard(size, size, 1)
+		b.Run(fmt.Sprintf("%vx%v", size, size), func(b *testing.B) {
+			for b.Loop() {
+				board = stepBounded(board, Conway)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package gameoflife
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+var frameBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gameOfLifeFrameBytes",
+	Help: "The number of bytes of board HTML sent to Game of Life viewers, by whether the frame was a delta or the full board",
+}, []string{"kind"})
+
+var deltaBytesSaved = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "gameOfLifeDeltaBytesSaved",
+	Help: "An estimate of the bytes saved by sending Game of Life viewers only the cells that changed instead of the full board",
+})
+
+// fullFrameSize is the size of the last full board rendered. Every board has the same cells and nearly the same
+// markup, so it stands in for the full board a delta replaces without rendering one just to measure it.
+var fullFrameSize atomic.Int64
+
+var frameRenderSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "gameOfLifeFrameRenderSeconds",
+	Help:    "The time taken to render a Game of Life frame, by whether it was a delta, the full board or the packed board for canvas viewers",
+	Buckets: prometheus.ExponentialBuckets(0.00001, 4, 8),
+}, []string{"kind"})
+
+// observeRender times a render of the given kind until the returned func is called.
+func observeRender(kind string) func() {
+	start := time.Now()
+	return func() {
+		frameRenderSeconds.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Past this many changed cells a delta is no smaller than the board, so the full board is sent instead.
+const maxDeltaCells = boardSizeX * boardSizeY / 2
+
+// Frame is a board as published to listeners, along with the cells that changed since the frame before it.
+type Frame struct {
+	Board    *GameBoard
+	Controls Controls
+	// Seq numbers frames in the order they were published, so a listener can tell whether it missed one.
+	Seq     uint64
+	Changes []TileUpdate
+	History History
+	// Stats are the recent generations' statistics, oldest first.
+	Stats     []Stats
+	Stability Stability
+	// basePath is where the board's room is served, which the rendered cells post their clicks to.
+	basePath string
+
+	// full is the rendered board, shared by every listener that needs it.
+	once sync.Once
+	full string
+	err  error
+	// canvas is the packed board as signals, shared the same way with canvas listeners.
+	canvasOnce sync.Once
+	canvas     []byte
+	canvasErr  error
+}
+
+// nextFrame publishes board as the frame after prev, or as the first frame when there is none.
+func nextFrame(prev *Frame, board *GameBoard, controls Controls, history History, basePath string) *Frame {
+	frame := &Frame{Board: board, Controls: controls, Seq: 1, History: history, basePath: basePath}
+	if prev != nil {
+		frame.Seq = prev.Seq + 1
+		frame.Changes = diff(&prev.Board.board, &board.board)
+	}
+	return frame
+}
+
+// diff lists the cells that differ between two boards, with their values in next.
+func diff(prev, next *[boardSizeX][boardSizeY]bool) []TileUpdate {
+	changes := []TileUpdate{}
+	for x := range boardSizeX {
+		for y := range boardSizeY {
+			if prev[x][y] != next[x][y] {
+				changes = append(changes, TileUpdate{X: uint(x), Y: uint(y), Value: next[x][y]})
+			}
+		}
+	}
+	return changes
+}
+
+func (f *Frame) renderFull() (string, error) {
+	f.once.Do(func() {
+		defer observeRender("full")()
+		f.full, f.err = renderHTML(GameOfLifeFragment(f.basePath, f.Board))
+		if f.err == nil {
+			fullFrameSize.Store(int64(len(f.full)))
+		}
+	})
+	return f.full, f.err
+}
+
+// renderDelta draws only the changed cells, which datastar morphs into the board by their ids.
+func (f *Frame) renderDelta() (string, error) {
+	defer observeRender("delta")()
+	var b strings.Builder
+	for _, change := range f.Changes {
+		err := Cell(fmt.Sprintf("%v-%v", change.X, change.Y), change.Value).Render(context.Background(), &b)
+		if err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func renderHTML(c templ.Component) (string, error) {
+	var b strings.Builder
+	if err := c.Render(context.Background(), &b); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// sendFrame brings a listener that last saw frame seq, or 0 for none, up to date with f. Only the changed cells are sent
+// when the listener saw the frame just before, and the full board otherwise. It returns the seq the listener has now seen.
+func sendFrame(sse *datastar.ServerSentEventGenerator, f *Frame, seq uint64) (uint64, error) {
+	if f.Seq <= seq {
+		return seq, nil
+	}
+	if seq > 0 && f.Seq == seq+1 && len(f.Changes) <= maxDeltaCells {
+		if len(f.Changes) == 0 {
+			return f.Seq, nil
+		}
+		delta, err := f.renderDelta()
+		if err != nil {
+			return seq, err
+		}
+		if err := sse.PatchElements(delta); err != nil {
+			return seq, err
+		}
+		frameBytes.WithLabelValues("delta").Add(float64(len(delta)))
+		// A listener's first frame is always the full board, so there is a size to compare against by now.
+		if size := fullFrameSize.Load(); size > int64(len(delta)) {
+			deltaBytesSaved.Add(float64(size - int64(len(delta))))
+		}
+		return f.Seq, nil
+	}
+	full, err := f.renderFull()
+	if err != nil {
+		return seq, err
+	}
+	if err := sse.PatchElements(full); err != nil {
+		return seq, err
+	}
+	frameBytes.WithLabelValues("full").Add(float64(len(full)))
+	return f.Seq, nil
+}
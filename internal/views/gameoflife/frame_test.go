@@ -0,0 +1,53 @@
+package gameoflife
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+// frameOf publishes board as the frame after prev.
+func frameOf(prev *Frame, board [boardSizeX][boardSizeY]bool) *Frame {
+	gb := NewGameBoard()
+	gb.SetBoard(board)
+	return nextFrame(prev, &gb, defaultControls(), History{}, "/gameoflife")
+}
+
+// TestSendFrameOnlyRendersWhatItSends checks that a listener kept up to date with deltas never costs a full render.
+func TestSendFrameOnlyRendersWhatItSends(t *testing.T) {
+	first := frameOf(nil, cells())
+	second := frameOf(first, cells([2]uint{3, 4}))
+
+	send := func(f *Frame, seq uint64) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		sse := datastar.NewSSE(w, httptest.NewRequest("GET", "/gameoflife", nil))
+		sent, err := sendFrame(sse, f, seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent != f.Seq {
+			t.Fatalf("sendFrame reports frame %v as seen, want %v", sent, f.Seq)
+		}
+		return w.Body.String()
+	}
+
+	send(first, 0)
+	if first.full == "" {
+		t.Fatal("a new listener was not sent the full board")
+	}
+	delta := send(second, first.Seq)
+	if second.full != "" {
+		t.Error("sending a delta rendered the full board as well")
+	}
+	if !strings.Contains(delta, `id="3-4"`) || strings.Contains(delta, `id="0-0"`) {
+		t.Errorf("delta does not hold just the changed cell:\n%v", delta)
+	}
+	// A listener that missed a frame is sent the whole board, rendered now.
+	send(second, 0)
+	if second.full == "" {
+		t.Error("a listener that missed a frame was not sent the full board")
+	}
+}
@@ -1,7 +1,11 @@
 package gameoflife
 
 import (
+	"apparently-experiments/internal/broker"
+	"apparently-experiments/internal/hub"
 	"apparently-experiments/internal/shared"
+	"apparently-experiments/internal/views/presence"
+	"context"
 	"fmt"
 	"log/slog"
 	"math/rand"
@@ -9,9 +13,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/a-h/templ"
+	"github.com/google/uuid"
 	"github.com/starfederation/datastar-go/datastar"
 )
 
@@ -19,29 +25,37 @@ import (
 // As a demo/prototype, configurability is not a requirement.
 const (
 	// Helper Constants for tick management
-	tickDurationMS = 500
+	tickDurationMS = 100
 	ticksPerSecond = 1000 / tickDurationMS
 	// Tick rates are for various conditions to save resources
 	// on the simulation as well as preserve the state when no one is watching.
-	activeTickRate = 1
-	idleTickRate   = 30 * ticksPerSecond
-	updateDelay    = 2 * ticksPerSecond
+	// While playing, the tick rate follows the speed set in the playback controls.
+	idleTickRate = 30 * ticksPerSecond
+	updateDelay  = 2 * ticksPerSecond
 	// Channel buffers to ensure that there are no interruptions when multiple sessions ocnnect at once.
 	channelBuffer = 10
 	// Due to the exponential increase in the complexity of this potential simulation, these are hard caps for the demo
 	boardSizeX = 50
 	boardSizeY = 50
+	// The rendered size of each cell, used to place cursors over the board.
+	cellSize = 10
 )
 
+// sessionCookie identifies a browser to the other viewers of a room, scoped to the Game of Life so it is never sent
+// to the other demos.
+var sessionCookie = shared.SessionCookie{Name: "gameoflife_session", Path: "/gameoflife"}
+
 type TileUpdate struct {
-	X     uint
-	Y     uint
-	Value bool
+	X     uint `json:"x"`
+	Y     uint `json:"y"`
+	Value bool `json:"value"`
 }
 
 type GameBoard struct {
-	rw    sync.RWMutex
-	board [boardSizeX][boardSizeY]bool
+	rw       sync.RWMutex
+	board    [boardSizeX][boardSizeY]bool
+	rule     Rule
+	topology Topology
 }
 
 func (gb *GameBoard) SetBoard(board [boardSizeX][boardSizeY]bool) {
@@ -70,191 +84,388 @@ func (gb *GameBoard) SetTile(x, y uint, value bool) error {
 	return nil
 }
 
+func (gb *GameBoard) Rule() Rule {
+	gb.rw.RLock()
+	defer gb.rw.RUnlock()
+	return gb.rule
+}
+
+func (gb *GameBoard) SetRule(rule Rule) {
+	gb.rw.Lock()
+	defer gb.rw.Unlock()
+	gb.rule = rule
+}
+
+func (gb *GameBoard) Topology() Topology {
+	gb.rw.RLock()
+	defer gb.rw.RUnlock()
+	return gb.topology
+}
+
+func (gb *GameBoard) SetTopology(topology Topology) {
+	gb.rw.Lock()
+	defer gb.rw.Unlock()
+	gb.topology = topology
+}
+
+// Population counts the live cells on the board.
+func (gb *GameBoard) Population() int {
+	gb.rw.RLock()
+	defer gb.rw.RUnlock()
+	alive := 0
+	for x := range boardSizeX {
+		for y := range boardSizeY {
+			if gb.board[x][y] {
+				alive++
+			}
+		}
+	}
+	return alive
+}
+
+// Snapshot returns a copy of the board that is safe to read without holding its lock.
+func (gb *GameBoard) Snapshot() *GameBoard {
+	gb.rw.RLock()
+	defer gb.rw.RUnlock()
+	return &GameBoard{board: gb.board, rule: gb.rule, topology: gb.topology}
+}
+
 func NewGameBoard() GameBoard {
 	return GameBoard{
 		rw:    sync.RWMutex{},
 		board: [boardSizeX][boardSizeY]bool{},
+		rule:  Conway,
 	}
 }
 
-// Creates a board with a semi^randomized starting position
-func NewRandomGameBoard() GameBoard {
-	board := [boardSizeX][boardSizeY]bool{}
-
-	for y := range boardSizeY {
-		for x := range boardSizeX {
-			if rand.Intn(2) == 0 {
-				board[x][y] = true
-			}
-		}
-	}
+// Creates a board with a semi^randomized starting position. The same seed and density always give the same board.
+func NewRandomGameBoard(seed int64, density int) GameBoard {
 	return GameBoard{
 		rw:    sync.RWMutex{},
-		board: board,
+		board: randomBoard(seed, density),
+		rule:  Conway,
 	}
 }
 
-type Handler struct {
-	tx            chan *TileUpdate
-	rx            []chan *GameBoard
-	addRx         chan chan *GameBoard
-	delRx         chan (<-chan *GameBoard)
+// room is a single board with its own ticker, listeners and broker topic.
+type room struct {
+	name     string
+	basePath string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	// done is closed once serve() has stopped and closed the hub and presence.
+	done chan struct{}
+	// lastActive is when the room last served a request, in unix nanoseconds.
+	lastActive atomic.Int64
+	tx         chan *TileUpdate
+	settings   chan Settings
+	commands   chan Command
+	remote     chan *replicaMessage
+	hub        *hub.Hub[*Frame]
+	// latest is the last frame published, which new listeners start from.
+	latest        atomic.Pointer[Frame]
+	broker        broker.Broker
 	board         GameBoard
+	presence      *presence.Tracker
 	ticksToUpdate uint
 	tickrate      uint
+	// controls is the playback state. Only touched by serve().
+	controls Controls
+	// origin identifies this replica in broker messages.
+	origin string
+	// generation counts ticks so replicas can tell whose board is newest. Only touched by serve().
+	generation uint64
+	// provisional is set while the board is the random one made up when the room opened, which gives way to the board
+	// of any replica that was given one. Only touched by serve().
+	provisional bool
+	// history keeps the last generations so players can rewind, or fork a new room from one of them.
+	history *history
+	stats   stats
 }
 
-func NewHandler() http.Handler {
-	h := &Handler{
-		tx:            make(chan *TileUpdate, channelBuffer),
-		rx:            make([]chan *GameBoard, 0),
-		addRx:         make(chan chan *GameBoard, channelBuffer),
-		delRx:         make(chan (<-chan *GameBoard), channelBuffer),
-		board:         NewRandomGameBoard(),
+// newRoom creates a game of life board served under basePath, starting from a random board unless start is given.
+// Edits and generations are published through b so that every replica sharing it converges on the same board.
+func newRoom(name, basePath string, b broker.Broker, start *replicaMessage) (*room, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &room{
+		name:     name,
+		basePath: basePath,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		tx:       make(chan *TileUpdate, channelBuffer),
+		settings: make(chan Settings, channelBuffer),
+		commands: make(chan Command, channelBuffer),
+		remote:   make(chan *replicaMessage, channelBuffer),
+		broker:   b,
+		origin:   uuid.New().String(),
+		// Each message is a whole board, so a slow viewer can skip straight to the newest generation.
+		hub:           hub.New[*Frame]("gameoflife:"+name, hub.Options{Policy: hub.Latest}),
+		board:         NewRandomGameBoard(rand.Int63(), defaultDensity),
+		presence:      presence.New("gameoflife:" + name),
 		ticksToUpdate: idleTickRate,
 		tickrate:      idleTickRate,
+		controls:      defaultControls(),
+		provisional:   true,
+	}
+	if start != nil && !h.adopt(start) {
+		cancel()
+		return nil, fmt.Errorf("game of life room %v could not start from the given board", name)
+	}
+	h.touch()
+	snapshot := h.board.Snapshot()
+	h.history = newHistory(h.generation, packBoard(&snapshot.board))
+	h.broadcast()
+	updates, err := b.Subscribe(ctx, name)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("game of life broker subscription failed: %w", err)
 	}
+	go h.receive(updates)
 	go h.serve()
-	return h
+	if h.provisional {
+		// The room may already be open on another replica, from a permalink or fork this one has never seen.
+		if err := h.publish(ctx, replicaMessage{Sync: true}); err != nil {
+			slog.Error("Failed to ask replicas for the game of life board", "room", name, "error", err)
+		}
+	}
+	return h, nil
+}
+
+func (h *room) touch() {
+	h.lastActive.Store(time.Now().UnixNano())
+}
+
+// idle reports whether nobody is watching the room and it has not been used for at least timeout.
+func (h *room) idle(timeout time.Duration) bool {
+	return h.hub.Count() == 0 && time.Since(time.Unix(0, h.lastActive.Load())) >= timeout
 }
 
-func (h *Handler) setTickRate(tickrate uint) {
+// close stops the room's workers and waits for serve() to close its hub and presence, whose metrics a room reopened
+// under the same name would otherwise lose.
+func (h *room) close() {
+	h.cancel()
+	<-h.done
+}
+
+// broadcast publishes the board to listeners as the next frame and returns the snapshot it was taken from.
+// Only called from serve().
+func (h *room) broadcast() *GameBoard {
+	snapshot := h.board.Snapshot()
+	packed := packBoard(&snapshot.board)
+	h.history.record(h.generation, packed)
+	frame := nextFrame(h.latest.Load(), snapshot, h.controls, h.history.span(h.generation), h.basePath)
+	stability := h.stats.stability
+	h.stats.observe(frame, packed)
+	if h.stats.stability != stability && h.stats.stability.Period > 0 {
+		slog.Info("Game of life board stabilized", "room", h.name, "period", h.stats.stability.Period, "since", h.stats.stability.Since)
+	}
+	frame.Stats, frame.Stability = h.stats.Series(), h.stats.stability
+	h.latest.Store(frame)
+	h.hub.Publish(frame)
+	return frame.Board
+}
+
+// advance plays the next generation and shares it with listeners and the other replicas.
+// Only called from serve().
+func (h *room) advance() {
+	slog.Debug("game update")
+	_ = h.tickGame()
+	h.generation++
+
+	snapshot := h.broadcast()
+	if h.controls.AutoReseed && h.stats.reseedDue() {
+		snapshot = h.reseed()
+	}
+	err := h.publish(h.ctx, replicaMessage{
+		Generation:  h.generation,
+		Board:       packBoard(&snapshot.board),
+		Settings:    snapshot.Settings(),
+		Provisional: h.provisional,
+	})
+	if err != nil {
+		slog.Error("Failed to publish game of life generation", "error", err)
+	}
+}
+
+func (h *room) setTickRate(tickrate uint) {
 	h.ticksToUpdate = tickrate
 	h.tickrate = tickrate
 }
 
-func (h *Handler) tickGame() int {
+func (h *room) tickGame() int {
+	h.board.rw.RLock()
+	newBoard, alive := step(&h.board.board, h.board.rule, h.board.topology)
+	h.board.rw.RUnlock()
+
+	h.board.SetBoard(newBoard)
+	return alive
+}
+
+// step works out the next generation of board under rule and counts its live cells.
+// Neighbours past the edges of the board are found according to topology.
+func step(board *[boardSizeX][boardSizeY]bool, rule Rule, topology Topology) ([boardSizeX][boardSizeY]bool, int) {
+	current, next := packRows(board), newBitBoard(boardSizeX, boardSizeY)
+	current.step(next, rule, topology)
+	return next.unpack(), next.population()
+}
+
+// stepNaive is step one cell at a time, kept to check and benchmark the bit-packed engine against.
+func stepNaive(board *[boardSizeX][boardSizeY]bool, rule Rule, topology Topology) ([boardSizeX][boardSizeY]bool, int) {
 	alive := 0
 	// Create the next frame
 	newBoard := [boardSizeX][boardSizeY]bool{}
-	h.board.rw.RLock()
 
 	for x := range boardSizeX {
 		for y := range boardSizeY {
-			// Calculate whether the cell should be alive or dead as per Conway's game of life rules
 			numNeighbors := 0
-
-			// Left neighbors
-			if x > 0 {
-				if y > 0 && h.board.board[x-1][y-1] {
-					numNeighbors++
-				}
-				if h.board.board[x-1][y] {
-					numNeighbors++
-				}
-				if y < boardSizeY-1 && h.board.board[x-1][y+1] {
-					numNeighbors++
-				}
-			}
-
-			// Middle neighbors
-			if y > 0 && h.board.board[x][y-1] {
-				numNeighbors++
-			}
-			if y < boardSizeY-1 && h.board.board[x][y+1] {
-				numNeighbors++
-			}
-
-			// Right neighbors
-			if x < boardSizeX-1 {
-				if y > 0 && h.board.board[x+1][y-1] {
-					numNeighbors++
-				}
-				if h.board.board[x+1][y] {
-					numNeighbors++
-				}
-				if y < boardSizeY-1 && h.board.board[x+1][y+1] {
-					numNeighbors++
+			for dx := -1; dx <= 1; dx++ {
+				for dy := -1; dy <= 1; dy++ {
+					if dx == 0 && dy == 0 {
+						continue
+					}
+					nx, ny, ok := topology.wrap(x+dx, y+dy)
+					if ok && board[nx][ny] {
+						numNeighbors++
+					}
 				}
 			}
 
-			// As per Game of life rules, a cell is living (true) if there is either 2 or three neighbors
-			// In all other quanitites, it dies (false)
-			if (newBoard[x][y] && numNeighbors == 2) || numNeighbors == 3 {
+			if rule.Next(board[x][y], numNeighbors) {
 				newBoard[x][y] = true
 				alive++
-			} else {
-				newBoard[x][y] = false
 			}
 		}
 	}
-	h.board.rw.RUnlock()
+	return newBoard, alive
+}
 
-	h.board.SetBoard(newBoard)
-	return alive
+func (h *room) applyTile(update *TileUpdate) {
+	err := h.board.SetTile(update.X, update.Y, update.Value)
+	if err != nil {
+		slog.Error("update tile error", "error", err)
+	}
 }
 
-func (h *Handler) serve() {
-	slog.Info("Game Of Life updater worker started")
+func (h *room) serve() {
+	defer close(h.done)
+	slog.Info("Game Of Life updater worker started", "room", h.name)
 	ticker := time.NewTicker(tickDurationMS * time.Millisecond)
 	defer ticker.Stop()
 
+	viewers := 0
 	for {
 		select {
+		case <-h.ctx.Done():
+			slog.Info("Game Of Life updater worker stopped", "room", h.name)
+			h.hub.Close()
+			h.presence.Close()
+			return
+
 		case update := <-h.tx:
 			h.setTickRate(updateDelay)
-			err := h.board.SetTile(update.X, update.Y, update.Value)
-			if err != nil {
-				slog.Error("update tile error", "error", err)
+			h.applyTile(update)
+			// A stamped pattern arrives as a burst of tiles, so apply everything queued before showing the result.
+		drain:
+			for {
+				select {
+				case update := <-h.tx:
+					h.applyTile(update)
+				default:
+					break drain
+				}
 			}
+			h.broadcast()
 
 		case <-ticker.C:
+			if h.controls.Paused {
+				continue
+			}
 			// Tick the counter until next update.
 			if h.ticksToUpdate > 0 {
 				h.ticksToUpdate--
 				continue
 			}
 			// If we have no listeners, don't bother actually ticking the simulation and set the time to update to 30 seconds.
-			if len(h.rx) == 0 {
+			if h.hub.Count() == 0 {
 				slog.Debug("no active connections skipping ticking will tick again in 30 seconds")
 				h.setTickRate(idleTickRate)
 				continue
 			} else {
-				h.setTickRate(activeTickRate)
+				h.setTickRate(h.controls.tickRate())
 			}
-			slog.Debug("game update")
-			_ = h.tickGame()
+			h.advance()
 
-			h.board.rw.RLock()
-
-			for _, rx := range h.rx {
-				rx <- &h.board
+		case settings := <-h.settings:
+			if err := h.board.Apply(settings); err != nil {
+				slog.Error("Discarding game of life settings", "error", err)
+				continue
+			}
+			slog.Info("Game of life settings changed", "rule", h.board.Rule(), "topology", h.board.Topology())
+			h.broadcast()
+
+		case cmd := <-h.commands:
+			h.command(cmd)
+
+		case msg := <-h.remote:
+			if msg.resync {
+				// Whatever was missed, the other replicas' boards cover it.
+				slog.Warn("Game of life replica messages may have been missed, asking for the board again", "room", h.name)
+				if err := h.publish(h.ctx, replicaMessage{Sync: true}); err != nil {
+					slog.Error("Failed to ask replicas for the game of life board", "room", h.name, "error", err)
+				}
+				continue
+			}
+			if msg.Sync {
+				h.sync()
+				continue
+			}
+			if h.adopt(msg) {
+				slog.Debug("Adopted game of life board from replica", "origin", msg.Origin, "generation", msg.Generation)
+				h.broadcast()
 			}
-			h.board.rw.RUnlock()
 
-		case channel := <-h.addRx:
-			slog.Debug("Opening channel")
-			h.setTickRate(activeTickRate)
+		case count := <-h.hub.Changes():
+			slog.Debug("game of life viewers changed", "viewers", count)
 			// If we were previously inactive and now are receiving our first connection
 			// Give the simulation 5 seconds to start by using the lowPopulationTickRate
-			h.rx = append(h.rx, channel)
-
-		case channel := <-h.delRx:
-			slog.Debug("Closing channel")
-			for i, ch := range h.rx {
-				if ch == channel {
-					h.rx[i] = h.rx[len(h.rx)-1]
-					h.rx = h.rx[:len(h.rx)-1]
-					close(ch)
-					break
-				}
+			if count > viewers {
+				h.setTickRate(h.controls.tickRate())
 			}
+			viewers = count
 		}
 	}
 }
 
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (h *room) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		h.fliptile(w, r)
+		if r.URL.Query().Has(presence.URI_PARAM_CURSOR) {
+			h.presence.ServeCursor(w, r, sessionCookie.Session(w, r))
+		} else if r.URL.Query().Has(URI_PARAM_RULE) || r.URL.Query().Has(URI_PARAM_TOPOLOGY) {
+			h.changeSettings(w, r)
+		} else if r.URL.Query().Has(URI_PARAM_CONTROL) {
+			h.control(w, r)
+		} else if r.URL.Query().Has(URI_PARAM_STAMP) {
+			h.stamp(w, r)
+		} else {
+			h.fliptile(w, r)
+		}
 
 	case http.MethodGet:
 		if r.URL.Query().Has("listen") {
 			h.listen(w, r)
+		} else if r.URL.Query().Has(URI_PARAM_RULE) || r.URL.Query().Has(URI_PARAM_TOPOLOGY) {
+			writeSettings(w, h.board.Snapshot().Settings())
+		} else if r.URL.Query().Has(URI_PARAM_EXPORT) {
+			h.export(w, r)
+		} else if r.URL.Query().Has(URI_PARAM_PERMALINK) {
+			h.sharePermalink(w, r)
 		} else {
 			h.board.rw.RLock()
 			defer h.board.rw.RUnlock()
-			templ.Handler(GameOfLife(&h.board)).ServeHTTP(w, r)
+			frame := h.latest.Load()
+			templ.Handler(GameOfLife(h.basePath, &h.board, frame, h.presence.Count(), r.URL.Query().Get(URI_PARAM_VIEW) == viewCanvas)).ServeHTTP(w, r)
 		}
 
 	default:
@@ -263,41 +474,83 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (h *Handler) listen(w http.ResponseWriter, r *http.Request) {
+func (h *room) listen(w http.ResponseWriter, r *http.Request) {
 	requestId := r.Header.Get(shared.RequestIDHeader)
 	slog.Debug("game of life listen()", "request_id", requestId)
+	sessionID := sessionCookie.Session(w, r)
 	sse := datastar.NewSSE(w, r)
-
-	err := sse.PatchElementTempl(GameOfLifeFragment(&h.board))
+	members := h.presence.Join(sse.Context(), sessionID)
+
+	// Subscribe before reading the latest frame so that no frame published in between is missed.
+	listener := h.hub.Subscribe(sse.Context())
+	frame := h.latest.Load()
+	send := sendFrame
+	if r.URL.Query().Get(URI_PARAM_VIEW) == viewCanvas {
+		send = sendCanvasFrame
+	}
+	seq, err := send(sse, frame, 0)
 	if err != nil {
 		_ = sse.ConsoleError(err)
 		return
 	}
-	listener := make(chan *GameBoard)
-	h.addRx <- listener
+	settings, controls, history, stability := frame.Board.Settings(), frame.Controls, frame.History, frame.Stability
+	generation := frame.History.Current
 	slog.Debug("game of life listener connected", "request_id", requestId)
 	// Keep the context open until the connection closes (detectable via the request context)
 	for {
 		select {
 		case <-sse.Context().Done():
 			slog.Debug("game of life listener disconnected", "request_id", requestId)
-			h.delRx <- listener
 			return
-		case msg := <-listener:
+		case msg, ok := <-listener.C:
+			if !ok {
+				return
+			}
 			slog.Debug("Update sending", "request_id", requestId)
 
 			if err = sse.Context().Err(); err != nil {
 				slog.Error("Context error", "err", err)
 				return
 			}
-			if err := sse.PatchElementTempl(GameOfLifeFragment(msg)); err != nil {
+			if seq, err = send(sse, msg, seq); err != nil {
+				slog.Error("Error occurred when patching", "error", err)
+			}
+			if msg.Board.Settings() != settings {
+				settings = msg.Board.Settings()
+				if err := sse.PatchElementTempl(SettingsPicker(h.basePath, msg.Board.rule, msg.Board.topology)); err != nil {
+					slog.Error("Error occurred when patching", "error", err)
+				}
+			}
+			if msg.Controls != controls {
+				controls = msg.Controls
+				if err := sse.PatchElementTempl(PlaybackControls(h.basePath, controls)); err != nil {
+					slog.Error("Error occurred when patching", "error", err)
+				}
+			}
+			if msg.History.Current != generation || msg.Stability != stability {
+				generation, stability = msg.History.Current, msg.Stability
+				if err := sse.PatchElementTempl(StatsPanel(msg.Stats, msg.Stability)); err != nil {
+					slog.Error("Error occurred when patching", "error", err)
+				}
+			}
+			if msg.History != history {
+				history = msg.History
+				if err := sse.PatchElementTempl(Scrubber(h.basePath, history)); err != nil {
+					slog.Error("Error occurred when patching", "error", err)
+				}
+			}
+		case state, ok := <-members.C:
+			if !ok {
+				return
+			}
+			if err := presence.Patch(sse, state, sessionID, cellSize); err != nil {
 				slog.Error("Error occurred when patching", "error", err)
 			}
 		}
 	}
 }
 
-func (h *Handler) fliptile(w http.ResponseWriter, r *http.Request) {
+func (h *room) fliptile(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("game of life fliptile()", "request_id", r.Header.Get(shared.RequestIDHeader))
 	sse := datastar.NewSSE(w, r)
 	id := r.URL.Query().Get("id")
@@ -329,10 +582,18 @@ func (h *Handler) fliptile(w http.ResponseWriter, r *http.Request) {
 		_ = sse.ConsoleError(err)
 		return
 	}
-	h.tx <- &TileUpdate{
+	err = h.publish(r.Context(), replicaMessage{Tile: &TileUpdate{
 		X: uint(x), Y: uint(y), Value: !isAlive,
+	}})
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
 	}
 
+	// The canvas view has no cell elements, and redraws once the change comes back from the board.
+	if r.URL.Query().Get(URI_PARAM_VIEW) == viewCanvas {
+		return
+	}
 	err = sse.PatchElementTempl(Cell(id, !isAlive))
 	if err != nil {
 		_ = sse.ConsoleError(err)
@@ -0,0 +1,149 @@
+package gameoflife
+
+import (
+	"apparently-experiments/internal/shared"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+const URI_PARAM_GENERATION = "generation"
+const URI_PARAM_FORK = "fork"
+
+// The number of past generations each room keeps. Boards are bit-packed, so this is about 80KB a room.
+const historySize = 256
+
+// History is the range of generations a room can rewind to, along with the one on the board.
+type History struct {
+	Oldest  uint64
+	Newest  uint64
+	Current uint64
+}
+
+// history keeps the most recent generations of a board, bit-packed and indexed by generation.
+// The generations it holds are always contiguous, from oldest to newest.
+type history struct {
+	mu     sync.Mutex
+	boards [historySize][]byte
+	oldest uint64
+	newest uint64
+}
+
+func newHistory(generation uint64, board []byte) *history {
+	hs := &history{oldest: generation, newest: generation}
+	hs.boards[generation%historySize] = board
+	return hs
+}
+
+// record stores board as the given generation. Generations after it are only kept while the board is the one they
+// grew from, so stepping forward after a rewind keeps the old future and editing the board replaces it.
+func (hs *history) record(generation uint64, board []byte) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if generation >= hs.oldest && generation <= hs.newest {
+		if bytes.Equal(hs.boards[generation%historySize], board) {
+			return
+		}
+		hs.newest = generation
+	} else if generation == hs.newest+1 {
+		hs.newest = generation
+	} else {
+		// The board jumped, for example to a replica's further along board, so nothing before it follows on.
+		hs.oldest, hs.newest = generation, generation
+	}
+	hs.boards[generation%historySize] = board
+	if hs.newest-hs.oldest >= historySize {
+		hs.oldest = hs.newest - historySize + 1
+	}
+}
+
+// board returns the given generation, if it is still held.
+func (hs *history) board(generation uint64) ([boardSizeX][boardSizeY]bool, bool) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if generation < hs.oldest || generation > hs.newest {
+		return [boardSizeX][boardSizeY]bool{}, false
+	}
+	board, err := unpackBoard(hs.boards[generation%historySize])
+	if err != nil {
+		slog.Error("Corrupt game of life history", "generation", generation, "error", err)
+		return board, false
+	}
+	return board, true
+}
+
+func (hs *history) span(current uint64) History {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return History{Oldest: hs.oldest, Newest: hs.newest, Current: current}
+}
+
+// seek puts a past generation back on the board for everyone, pausing it there. It must only be called from the serve() worker.
+func (h *room) seek(generation uint64) {
+	board, ok := h.history.board(generation)
+	if !ok {
+		slog.Warn("Game of life generation is no longer in the history", "room", h.name, "generation", generation)
+		return
+	}
+	h.controls.Paused = true
+	h.board.SetBoard(board)
+	h.generation = generation
+}
+
+// fork opens a new room starting from one of source's past generations and sends the player there.
+func (h *Handler) fork(w http.ResponseWriter, r *http.Request, source *room) {
+	slog.Debug("game of life fork()", "request_id", r.Header.Get(shared.RequestIDHeader))
+	generation, err := strconv.ParseUint(r.URL.Query().Get(URI_PARAM_FORK), 10, 64)
+	if err == nil {
+		if board, ok := source.history.board(generation); ok {
+			start := &replicaMessage{Generation: generation, Board: packBoard(&board), Settings: source.board.Settings()}
+			var forked *room
+			if forked, err = h.open("fork-"+uuid.New().String()[:8], start); err == nil {
+				h.redirect(w, r, forked.basePath)
+				return
+			}
+		} else {
+			err = fmt.Errorf("generation %v is no longer in the history", generation)
+		}
+	}
+
+	if r.Header.Get("Datastar-Request") != "true" {
+		status := http.StatusBadRequest
+		if errors.Is(err, errTooManyRooms) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	_ = datastar.NewSSE(w, r).ConsoleError(err)
+}
+
+func (h *Handler) redirect(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Header.Get("Datastar-Request") != "true" {
+		http.Redirect(w, r, path, http.StatusSeeOther)
+		return
+	}
+	if err := datastar.NewSSE(w, r).Redirect(path); err != nil {
+		slog.Error("Error occurred when redirecting", "error", err)
+	}
+}
+
+func seekExpression(basePath string, generation uint64) string {
+	return fmt.Sprintf("@post('%v?%v=%v&%v=%v')", basePath, URI_PARAM_CONTROL, ActionSeek, URI_PARAM_GENERATION, generation)
+}
+
+// The scrubber posts when released rather than while it is dragged.
+func scrubExpression(basePath string) string {
+	return fmt.Sprintf("@post('%v?%v=%v&%v=' + evt.target.value)", basePath, URI_PARAM_CONTROL, ActionSeek, URI_PARAM_GENERATION)
+}
+
+func forkExpression(basePath string, generation uint64) string {
+	return fmt.Sprintf("@post('%v?%v=%v')", basePath, URI_PARAM_FORK, generation)
+}
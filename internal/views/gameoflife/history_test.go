@@ -0,0 +1,84 @@
+package gameoflife
+
+import "testing"
+
+// numbered is a board telling generation n apart from the others held in a history.
+func numbered(n uint64) []byte {
+	board := [boardSizeX][boardSizeY]bool{}
+	board[n%boardSizeX][n/boardSizeX%boardSizeY] = true
+	return packBoard(&board)
+}
+
+// checkHeld checks that the history holds exactly the generations from oldest to newest, each with its own board.
+func checkHeld(t *testing.T, hs *history, oldest, newest uint64, boards map[uint64][]byte) {
+	t.Helper()
+	if span := hs.span(newest); span.Oldest != oldest || span.Newest != newest {
+		t.Fatalf("history holds generations %v to %v, want %v to %v", span.Oldest, span.Newest, oldest, newest)
+	}
+	if _, ok := hs.board(newest + 1); ok {
+		t.Errorf("generation %v is past the newest but was returned", newest+1)
+	}
+	for generation := oldest; generation <= newest; generation++ {
+		board, ok := hs.board(generation)
+		if !ok {
+			t.Fatalf("generation %v is missing", generation)
+		}
+		if packed := packBoard(&board); string(packed) != string(boards[generation]) {
+			t.Fatalf("generation %v holds the wrong board", generation)
+		}
+	}
+}
+
+func TestHistoryWrapsAround(t *testing.T) {
+	hs := newHistory(10, numbered(10))
+	boards := map[uint64][]byte{10: numbered(10)}
+	for generation := uint64(11); generation < 10+historySize*2+5; generation++ {
+		boards[generation] = numbered(generation)
+		hs.record(generation, boards[generation])
+	}
+	newest := uint64(10 + historySize*2 + 4)
+	checkHeld(t, hs, newest-historySize+1, newest, boards)
+	if _, ok := hs.board(newest - historySize); ok {
+		t.Error("the generation overwritten by the newest is still returned")
+	}
+}
+
+func TestHistoryRewind(t *testing.T) {
+	hs := newHistory(0, numbered(0))
+	boards := map[uint64][]byte{0: numbered(0)}
+	for generation := uint64(1); generation <= 20; generation++ {
+		boards[generation] = numbered(generation)
+		hs.record(generation, boards[generation])
+	}
+
+	// Rewinding puts an old generation back on the board unchanged, so the generations after it are kept.
+	hs.record(5, boards[5])
+	checkHeld(t, hs, 0, 20, boards)
+
+	// Editing the rewound board replaces its future.
+	edited := numbered(1000)
+	hs.record(5, edited)
+	boards[5] = edited
+	checkHeld(t, hs, 0, 5, boards)
+	if _, ok := hs.board(6); ok {
+		t.Error("generation 6 of the old future is still returned after an edit")
+	}
+
+	// Play carries on from the edit.
+	boards[6] = numbered(2000)
+	hs.record(6, boards[6])
+	checkHeld(t, hs, 0, 6, boards)
+}
+
+func TestHistoryJump(t *testing.T) {
+	hs := newHistory(0, numbered(0))
+	for generation := uint64(1); generation <= 3; generation++ {
+		hs.record(generation, numbered(generation))
+	}
+	// A board from another replica far ahead has no past here.
+	hs.record(500, numbered(500))
+	checkHeld(t, hs, 500, 500, map[uint64][]byte{500: numbered(500)})
+	if _, ok := hs.board(3); ok {
+		t.Error("generation 3 is still returned after jumping ahead")
+	}
+}
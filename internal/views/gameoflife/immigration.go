@@ -0,0 +1,404 @@
+package gameoflife
+
+import (
+	"apparently-experiments/internal/hub"
+	"apparently-experiments/internal/shared"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+const (
+	URI_PARAM_TEAM = "team"
+	// The team a player has joined is kept in a cookie. Anyone may join either team, so there is nothing to protect.
+	teamCookie = "gameoflife-team"
+	// Immigration plays two generations a second on a torus, so the teams keep running into each other.
+	immigrationTickMS   = 500
+	immigrationTopology = Torus
+	// Placing a cell holds the board for a couple of seconds so players can build before it moves on.
+	immigrationPause = 4
+	// The share of cells each team starts with, in percent.
+	immigrationDensity = 20
+)
+
+// Team owns a live cell in the Immigration variant. TeamNone marks a dead cell.
+type Team uint8
+
+const (
+	TeamNone Team = iota
+	TeamRed
+	TeamBlue
+)
+
+// teams lists every team players can join, in the order they are shown.
+var teams = []Team{TeamRed, TeamBlue}
+
+var teamNames = map[Team]string{
+	TeamRed:  "red",
+	TeamBlue: "blue",
+}
+
+func (t Team) String() string {
+	return teamNames[t]
+}
+
+// Label is the team's name as shown to players.
+func (t Team) Label() string {
+	switch t {
+	case TeamRed:
+		return "Red"
+	case TeamBlue:
+		return "Blue"
+	default:
+		return "Nobody"
+	}
+}
+
+func ParseTeam(s string) (Team, error) {
+	for team, name := range teamNames {
+		if name == s {
+			return team, nil
+		}
+	}
+	return TeamNone, fmt.Errorf("unknown team %q, expected red or blue", s)
+}
+
+// ImmigrationBoard holds the owner of every cell.
+type ImmigrationBoard [boardSizeX][boardSizeY]Team
+
+// Scores counts each team's live cells, indexed by Team.
+type Scores [TeamBlue + 1]int
+
+// stepImmigration plays a generation of Immigration: the cells live and die by Conway's rules, survivors keep their
+// team and a newborn cell joins the team most of its parents belong to.
+func stepImmigration(board *ImmigrationBoard) ImmigrationBoard {
+	next := ImmigrationBoard{}
+	for x := range boardSizeX {
+		for y := range boardSizeY {
+			parents := Scores{}
+			neighbours := 0
+			for dx := -1; dx <= 1; dx++ {
+				for dy := -1; dy <= 1; dy++ {
+					if dx == 0 && dy == 0 {
+						continue
+					}
+					nx, ny, ok := immigrationTopology.wrap(x+dx, y+dy)
+					if ok && board[nx][ny] != TeamNone {
+						parents[board[nx][ny]]++
+						neighbours++
+					}
+				}
+			}
+
+			alive := board[x][y] != TeamNone
+			if !Conway.Next(alive, neighbours) {
+				continue
+			}
+			team := board[x][y]
+			if !alive {
+				team = parents.leader()
+			}
+			next[x][y] = team
+		}
+	}
+	return next
+}
+
+// leader is the team with the most cells. Conway births need exactly three parents, so two teams never tie.
+func (s Scores) leader() Team {
+	leader := teams[0]
+	for _, team := range teams[1:] {
+		if s[team] > s[leader] {
+			leader = team
+		}
+	}
+	return leader
+}
+
+// score counts each team's live cells on board.
+func score(board *ImmigrationBoard) Scores {
+	scores := Scores{}
+	for x := range boardSizeX {
+		for y := range boardSizeY {
+			if board[x][y] != TeamNone {
+				scores[board[x][y]]++
+			}
+		}
+	}
+	return scores
+}
+
+// randomImmigrationBoard gives each team the same chance of owning each cell.
+func randomImmigrationBoard(seed int64) ImmigrationBoard {
+	random := rand.New(rand.NewSource(seed))
+	board := ImmigrationBoard{}
+	for y := range boardSizeY {
+		for x := range boardSizeX {
+			if chance := random.Intn(100); chance < immigrationDensity*len(teams) {
+				board[x][y] = teams[chance%len(teams)]
+			}
+		}
+	}
+	return board
+}
+
+// ImmigrationFrame is a generation as published to listeners.
+type ImmigrationFrame struct {
+	Board      ImmigrationBoard
+	Scores     Scores
+	Generation uint64
+}
+
+// placement is a player putting a cell of their team on the board, or taking one of their own away.
+type placement struct {
+	X, Y uint
+	Team Team
+}
+
+// ImmigrationHandler serves the two team Immigration variant. Like the unbounded universe, it only lives on this replica.
+type ImmigrationHandler struct {
+	rw    sync.RWMutex
+	board ImmigrationBoard
+	tx    chan placement
+	reset chan struct{}
+	hub   *hub.Hub[*ImmigrationFrame]
+	// latest is the last frame published, which new listeners start from.
+	latest atomic.Pointer[ImmigrationFrame]
+}
+
+func NewImmigrationHandler() http.Handler {
+	h := &ImmigrationHandler{
+		board: randomImmigrationBoard(rand.Int63()),
+		tx:    make(chan placement, channelBuffer),
+		reset: make(chan struct{}, 1),
+		hub:   hub.New[*ImmigrationFrame]("gameoflife:immigration", hub.Options{Policy: hub.Latest}),
+	}
+	h.latest.Store(&ImmigrationFrame{Board: h.board, Scores: score(&h.board)})
+	go h.serve()
+	return h
+}
+
+func (h *ImmigrationHandler) serve() {
+	slog.Info("Immigration Game Of Life worker started")
+	ticker := time.NewTicker(immigrationTickMS * time.Millisecond)
+	defer ticker.Stop()
+
+	var generation uint64
+	var pause uint
+	for {
+		select {
+		case p := <-h.tx:
+			if !h.place(p) {
+				continue
+			}
+			pause = immigrationPause
+		case <-h.reset:
+			h.rw.Lock()
+			h.board = randomImmigrationBoard(rand.Int63())
+			h.rw.Unlock()
+			generation = 0
+		case <-ticker.C:
+			if pause > 0 {
+				pause--
+				continue
+			}
+			// Nobody is playing, so leave the board where it is until they come back.
+			if h.hub.Count() == 0 {
+				continue
+			}
+			h.rw.Lock()
+			h.board = stepImmigration(&h.board)
+			h.rw.Unlock()
+			generation++
+		}
+		h.rw.RLock()
+		frame := &ImmigrationFrame{Board: h.board, Scores: score(&h.board), Generation: generation}
+		h.rw.RUnlock()
+		h.latest.Store(frame)
+		h.hub.Publish(frame)
+	}
+}
+
+// place puts a cell of the player's team on an empty square or takes away one of their own.
+// Cells of the other team are left alone. It must only be called from the serve() worker.
+func (h *ImmigrationHandler) place(p placement) bool {
+	h.rw.Lock()
+	defer h.rw.Unlock()
+	switch h.board[p.X][p.Y] {
+	case TeamNone:
+		h.board[p.X][p.Y] = p.Team
+	case p.Team:
+		h.board[p.X][p.Y] = TeamNone
+	default:
+		return false
+	}
+	return true
+}
+
+func (h *ImmigrationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if r.URL.Query().Has(URI_PARAM_RESET) {
+			// A reset already waiting covers this one too, and the handler must not wait on the worker.
+			select {
+			case h.reset <- struct{}{}:
+			default:
+			}
+			w.WriteHeader(http.StatusNoContent)
+		} else if r.URL.Query().Has(URI_PARAM_TEAM) {
+			h.join(w, r)
+		} else {
+			h.placeCell(w, r)
+		}
+
+	case http.MethodGet:
+		if r.URL.Query().Has("listen") {
+			h.listen(w, r)
+		} else {
+			templ.Handler(Immigration(h.latest.Load(), playerTeam(r))).ServeHTTP(w, r)
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// playerTeam is the team the player has joined, or TeamNone if they are only watching.
+func playerTeam(r *http.Request) Team {
+	cookie, err := r.Cookie(teamCookie)
+	if err != nil {
+		return TeamNone
+	}
+	team, err := ParseTeam(cookie.Value)
+	if err != nil {
+		return TeamNone
+	}
+	return team
+}
+
+func (h *ImmigrationHandler) join(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("immigration join()", "request_id", r.Header.Get(shared.RequestIDHeader))
+	team, err := ParseTeam(r.URL.Query().Get(URI_PARAM_TEAM))
+	if err == nil {
+		http.SetCookie(w, &http.Cookie{
+			Name:     teamCookie,
+			Value:    team.String(),
+			Path:     "/gameoflife/immigration",
+			MaxAge:   int((24 * time.Hour).Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	sse := datastar.NewSSE(w, r)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	if err := sse.PatchElementTempl(TeamPicker(team)); err != nil {
+		_ = sse.ConsoleError(err)
+	}
+}
+
+func (h *ImmigrationHandler) placeCell(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("immigration placeCell()", "request_id", r.Header.Get(shared.RequestIDHeader))
+	team := playerTeam(r)
+	sse := datastar.NewSSE(w, r)
+	if team == TeamNone {
+		_ = sse.ConsoleError(fmt.Errorf("join a team before placing cells"))
+		return
+	}
+
+	xcomponent, ycomponent, found := strings.Cut(strings.TrimPrefix(r.URL.Query().Get("id"), "i-"), "-")
+	if !found {
+		_ = sse.ConsoleError(fmt.Errorf("%v, %v was malformed", xcomponent, ycomponent))
+		return
+	}
+	x, err := strconv.Atoi(xcomponent)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	y, err := strconv.Atoi(ycomponent)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	if uint(x) >= boardSizeX || uint(y) >= boardSizeY {
+		_ = sse.ConsoleError(fmt.Errorf("Cell position (%v, %v) is out of bounds", x, y))
+		return
+	}
+
+	h.rw.RLock()
+	owner := h.board[x][y]
+	h.rw.RUnlock()
+	if owner != TeamNone && owner != team {
+		_ = sse.ConsoleError(fmt.Errorf("Cell (%v, %v) belongs to team %v", x, y, owner.Label()))
+		return
+	}
+	// Like a reset, the handler must not wait on a worker that has fallen behind, so a full queue turns the cell away.
+	select {
+	case h.tx <- placement{X: uint(x), Y: uint(y), Team: team}:
+	case <-r.Context().Done():
+	default:
+		_ = sse.ConsoleError(fmt.Errorf("the board is busy, please try again"))
+	}
+}
+
+func (h *ImmigrationHandler) sendFrame(sse *datastar.ServerSentEventGenerator, frame *ImmigrationFrame) error {
+	if err := sse.PatchElementTempl(ImmigrationBoardFragment(frame)); err != nil {
+		return err
+	}
+	return sse.PatchElementTempl(Scoreboard(frame))
+}
+
+func (h *ImmigrationHandler) listen(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Header.Get(shared.RequestIDHeader)
+	slog.Debug("immigration listen()", "request_id", requestId)
+	sse := datastar.NewSSE(w, r)
+
+	// Subscribe before reading the latest frame so that no frame published in between is missed.
+	listener := h.hub.Subscribe(sse.Context())
+	if err := h.sendFrame(sse, h.latest.Load()); err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	for {
+		select {
+		case <-sse.Context().Done():
+			slog.Debug("immigration listener disconnected", "request_id", requestId)
+			return
+		case frame, ok := <-listener.C:
+			if !ok {
+				return
+			}
+			if err := h.sendFrame(sse, frame); err != nil {
+				slog.Error("Error occurred when patching", "error", err)
+			}
+		}
+	}
+}
+
+// teamClass is the colour a team's cells and buttons are drawn in.
+func teamClass(team Team) string {
+	switch team {
+	case TeamRed:
+		return "bg-error"
+	case TeamBlue:
+		return "bg-info"
+	default:
+		return "bg-base-100"
+	}
+}
+
+func joinExpression(team Team) string {
+	return fmt.Sprintf("@post('/gameoflife/immigration?%v=%v')", URI_PARAM_TEAM, team)
+}
@@ -0,0 +1,116 @@
+package gameoflife
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// teamPattern places a drawing of red ('r') and blue ('b') cells on an empty board with its top left at (x0, y0).
+func teamPattern(x0, y0 uint, rows ...string) ImmigrationBoard {
+	board := ImmigrationBoard{}
+	for dy, row := range rows {
+		for dx, c := range row {
+			switch c {
+			case 'r':
+				board[x0+uint(dx)][y0+uint(dy)] = TeamRed
+			case 'b':
+				board[x0+uint(dx)][y0+uint(dy)] = TeamBlue
+			}
+		}
+	}
+	return board
+}
+
+func TestImmigrationBirthsJoinTheMajority(t *testing.T) {
+	tests := []struct {
+		name  string
+		start []string
+		want  []string
+	}{
+		// The cells above and below the middle of a blinker are born with the team holding two of its three cells.
+		{name: "two red parents", start: []string{"...", "rbr", "..."}, want: []string{".r.", ".b.", ".r."}},
+		{name: "two blue parents", start: []string{"...", "brb", "..."}, want: []string{".b.", ".r.", ".b."}},
+		{name: "one team", start: []string{"...", "bbb", "..."}, want: []string{".b.", ".b.", ".b."}},
+		// The blinker's middle cell survives on its own team, whatever its neighbours.
+		{name: "survivor keeps its team", start: []string{".b.", ".r.", ".b."}, want: []string{"...", "brb", "..."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			board := teamPattern(20, 20, tt.start...)
+			got := stepImmigration(&board)
+			want := teamPattern(20, 20, tt.want...)
+			if got != want {
+				t.Errorf("after a generation from %q got %v, want %q", tt.start, render3(&got), tt.want)
+			}
+		})
+	}
+}
+
+// render3 draws the 3x3 area the patterns under test are drawn in, for failure messages.
+func render3(board *ImmigrationBoard) []string {
+	rows := make([]string, 0, 3)
+	for y := uint(20); y < 23; y++ {
+		row := ""
+		for x := uint(20); x < 23; x++ {
+			switch board[x][y] {
+			case TeamRed:
+				row += "r"
+			case TeamBlue:
+				row += "b"
+			default:
+				row += "."
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestScoresLeader(t *testing.T) {
+	tests := []struct {
+		scores Scores
+		want   Team
+	}{
+		{Scores{TeamRed: 2, TeamBlue: 1}, TeamRed},
+		{Scores{TeamRed: 1, TeamBlue: 2}, TeamBlue},
+		{Scores{TeamRed: 3}, TeamRed},
+		{Scores{TeamBlue: 3}, TeamBlue},
+	}
+	for _, tt := range tests {
+		if got := tt.scores.leader(); got != tt.want {
+			t.Errorf("%v.leader() = %v, want %v", tt.scores, got, tt.want)
+		}
+	}
+}
+
+func TestImmigrationScore(t *testing.T) {
+	board := teamPattern(0, 0, "rrb", ".b.", "r..")
+	if got, want := score(&board), (Scores{TeamRed: 3, TeamBlue: 2}); got != want {
+		t.Errorf("score = %v, want %v", got, want)
+	}
+}
+
+func TestPlaceCellWhileBusy(t *testing.T) {
+	// Nothing takes placements off an unbuffered queue, as if the worker were stuck on a generation.
+	h := &ImmigrationHandler{tx: make(chan placement)}
+	r := httptest.NewRequest("POST", "/gameoflife/immigration?id=i-3-4", nil)
+	r.AddCookie(&http.Cookie{Name: teamCookie, Value: TeamRed.String()})
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(w, r)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("placing a cell waited on a busy worker")
+	}
+	if !strings.Contains(w.Body.String(), "busy") {
+		t.Errorf("the player was not told the board is busy:\n%v", w.Body.String())
+	}
+}
@@ -0,0 +1,432 @@
+package gameoflife
+
+import (
+	"apparently-experiments/internal/shared"
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+const URI_PARAM_EXPORT = "export"
+const URI_PARAM_STAMP = "stamp"
+const URI_PARAM_X = "x"
+const URI_PARAM_Y = "y"
+
+const (
+	formatRLE       = "rle"
+	formatPlaintext = "cells"
+)
+
+// The largest pattern accepted in either format, far more than fits on a board but small enough to parse safely.
+const maxPatternSide = 4096
+
+// The most live cells accepted in a pattern. Patterns come from players, and a few bytes of RLE can describe millions
+// of cells, so this bounds the memory any one pattern can take.
+const maxPatternCells = 1 << 18
+
+// The largest pattern file accepted by the stamp endpoint.
+const maxPatternBody = 1 << 20
+
+// Point is the position of a live cell within a pattern.
+type Point struct {
+	X uint
+	Y uint
+}
+
+// Pattern is a drawing of live cells, as shared on the LifeWiki in the RLE and plaintext .cells formats.
+type Pattern struct {
+	Name   string
+	Width  uint
+	Height uint
+	// Rule is the rule the pattern was designed for in B/S notation, if known.
+	Rule  string
+	Cells []Point
+}
+
+// ParsePattern reads a pattern in either format, telling them apart by their header.
+func ParsePattern(text string) (Pattern, error) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#") || strings.HasPrefix(line, "x"):
+			return ParseRLE(text)
+		default:
+			return ParsePlaintext(text)
+		}
+	}
+	return Pattern{}, fmt.Errorf("pattern is empty")
+}
+
+// ParseRLE reads a pattern in run length encoding:
+// optional #N, #C and #O comment lines, a header such as x = 3, y = 3, rule = B3/S23, and then rows of runs
+// such as 2bo for two dead cells followed by a live one, with $ ending a row and ! ending the pattern.
+func ParseRLE(text string) (Pattern, error) {
+	p := Pattern{}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	header := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if strings.HasPrefix(line, "#N") {
+				p.Name = strings.TrimSpace(line[2:])
+			}
+			continue
+		}
+		if !header {
+			if err := p.parseRLEHeader(line); err != nil {
+				return p, err
+			}
+			header = true
+			continue
+		}
+
+		var body strings.Builder
+		body.WriteString(line)
+		for scanner.Scan() {
+			body.WriteString(strings.TrimSpace(scanner.Text()))
+		}
+		return p, p.parseRLEBody(body.String())
+	}
+	if err := scanner.Err(); err != nil {
+		return p, err
+	}
+	if !header {
+		return p, fmt.Errorf("RLE pattern has no header")
+	}
+	return p, nil
+}
+
+func (p *Pattern) parseRLEHeader(line string) error {
+	for _, field := range strings.Split(line, ",") {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return fmt.Errorf("RLE header field %q is not of the form key = value", field)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "x", "y":
+			n, err := strconv.ParseUint(value, 10, 0)
+			if err != nil || n > maxPatternSide {
+				return fmt.Errorf("RLE header has an invalid %v of %q", key, value)
+			}
+			if key == "x" {
+				p.Width = uint(n)
+			} else {
+				p.Height = uint(n)
+			}
+		case "rule":
+			rule, err := ParseRule(value)
+			if err != nil {
+				return err
+			}
+			p.Rule = rule.String()
+		}
+	}
+	return nil
+}
+
+func (p *Pattern) parseRLEBody(body string) error {
+	var x, y, run uint
+	for _, c := range body {
+		switch {
+		case c >= '0' && c <= '9':
+			run = run*10 + uint(c-'0')
+			if run > maxPatternSide {
+				return fmt.Errorf("RLE run of %v is too long", run)
+			}
+			continue
+		case c == '!':
+			return nil
+		case unicode.IsSpace(c):
+			continue
+		}
+
+		count := max(run, 1)
+		run = 0
+		switch c {
+		case '$':
+			x, y = 0, y+count
+		case 'b', '.':
+			x += count
+		default:
+			// Multi-state patterns use other letters for their states, which are all alive in a Life-like rule.
+			if !unicode.IsLetter(c) {
+				return fmt.Errorf("RLE pattern has an unexpected %q", c)
+			}
+			if x+count > maxPatternSide || y >= maxPatternSide {
+				return fmt.Errorf("RLE pattern is larger than %v cells across", maxPatternSide)
+			}
+			if uint(len(p.Cells))+count > maxPatternCells {
+				return fmt.Errorf("RLE pattern has more than %v live cells", maxPatternCells)
+			}
+			for range count {
+				p.Cells = append(p.Cells, Point{X: x, Y: y})
+				x++
+			}
+			p.Width, p.Height = max(p.Width, x), max(p.Height, y+1)
+		}
+		if x > maxPatternSide || y > maxPatternSide {
+			return fmt.Errorf("RLE pattern is larger than %v cells across", maxPatternSide)
+		}
+	}
+	return nil
+}
+
+// ParsePlaintext reads a pattern in the plaintext .cells format:
+// lines starting with ! are comments, with !Name: giving the pattern's name, and every other line is a row
+// with O for a live cell and . for a dead one.
+func ParsePlaintext(text string) (Pattern, error) {
+	p := Pattern{}
+	y := uint(0)
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "!") {
+			if name, found := strings.CutPrefix(line, "!Name:"); found {
+				p.Name = strings.TrimSpace(name)
+			}
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r")
+		if line != "" {
+			if y >= maxPatternSide {
+				return p, fmt.Errorf("plaintext pattern is larger than %v cells across", maxPatternSide)
+			}
+			p.Height = y + 1
+		}
+		for x, c := range []rune(line) {
+			if x >= maxPatternSide {
+				return p, fmt.Errorf("plaintext pattern is larger than %v cells across", maxPatternSide)
+			}
+			switch c {
+			case 'O', 'o', '*':
+				if len(p.Cells) >= maxPatternCells {
+					return p, fmt.Errorf("plaintext pattern has more than %v live cells", maxPatternCells)
+				}
+				p.Cells = append(p.Cells, Point{X: uint(x), Y: y})
+			case '.':
+			default:
+				return p, fmt.Errorf("plaintext pattern has an unexpected %q on row %v", c, y+1)
+			}
+			p.Width = max(p.Width, uint(x)+1)
+		}
+		y++
+	}
+	return p, nil
+}
+
+// PatternFromBoard captures the whole board, dead space included, so that it can be restored in place.
+func PatternFromBoard(board *GameBoard) Pattern {
+	board.rw.RLock()
+	defer board.rw.RUnlock()
+	p := Pattern{Width: boardSizeX, Height: boardSizeY, Rule: board.rule.String()}
+	for y := range uint(boardSizeY) {
+		for x := range uint(boardSizeX) {
+			if board.board[x][y] {
+				p.Cells = append(p.Cells, Point{X: x, Y: y})
+			}
+		}
+	}
+	return p
+}
+
+// grid lays the pattern out as rows of cells.
+func (p Pattern) grid() [][]bool {
+	rows := make([][]bool, p.Height)
+	for y := range rows {
+		rows[y] = make([]bool, p.Width)
+	}
+	for _, cell := range p.Cells {
+		rows[cell.Y][cell.X] = true
+	}
+	return rows
+}
+
+// RLE writes the pattern in run length encoding, wrapping lines at 70 characters as the format recommends.
+func (p Pattern) RLE() string {
+	var out strings.Builder
+	if p.Name != "" {
+		fmt.Fprintf(&out, "#N %v\n", p.Name)
+	}
+	fmt.Fprintf(&out, "x = %v, y = %v", p.Width, p.Height)
+	if p.Rule != "" {
+		fmt.Fprintf(&out, ", rule = %v", p.Rule)
+	}
+	out.WriteByte('\n')
+
+	line := 0
+	write := func(count uint, tag byte) {
+		token := string(tag)
+		if count > 1 {
+			token = strconv.FormatUint(uint64(count), 10) + token
+		}
+		if line+len(token) > 70 {
+			out.WriteByte('\n')
+			line = 0
+		}
+		out.WriteString(token)
+		line += len(token)
+	}
+
+	pendingRows := uint(0)
+	for _, row := range p.grid() {
+		// Trailing dead cells are implied by the end of the row, and blank rows fold into the next $.
+		end := len(row)
+		for end > 0 && !row[end-1] {
+			end--
+		}
+		if end == 0 {
+			pendingRows++
+			continue
+		}
+		if pendingRows > 0 {
+			write(pendingRows, '$')
+		}
+		for x := 0; x < end; {
+			run := 1
+			for x+run < end && row[x+run] == row[x] {
+				run++
+			}
+			tag := byte('b')
+			if row[x] {
+				tag = 'o'
+			}
+			write(uint(run), tag)
+			x += run
+		}
+		pendingRows = 1
+	}
+	write(1, '!')
+	out.WriteByte('\n')
+	return out.String()
+}
+
+// Plaintext writes the pattern in the .cells format.
+func (p Pattern) Plaintext() string {
+	var out strings.Builder
+	if p.Name != "" {
+		fmt.Fprintf(&out, "!Name: %v\n", p.Name)
+	}
+	for _, row := range p.grid() {
+		for _, alive := range row {
+			if alive {
+				out.WriteByte('O')
+			} else {
+				out.WriteByte('.')
+			}
+		}
+		out.WriteByte('\n')
+	}
+	return out.String()
+}
+
+// Stamp returns the tiles that draw the pattern with its top left at (x0, y0), dead cells included so that it
+// appears exactly as drawn. Anything falling off the board is left out.
+func (p Pattern) Stamp(x0, y0 uint) []TileUpdate {
+	alive := make(map[Point]bool, len(p.Cells))
+	for _, cell := range p.Cells {
+		alive[cell] = true
+	}
+	tiles := make([]TileUpdate, 0)
+	for y := uint(0); y < p.Height && y0+y < boardSizeY; y++ {
+		for x := uint(0); x < p.Width && x0+x < boardSizeX; x++ {
+			tiles = append(tiles, TileUpdate{X: x0 + x, Y: y0 + y, Value: alive[Point{X: x, Y: y}]})
+		}
+	}
+	return tiles
+}
+
+// export downloads the whole board in the format named by the export parameter, rle or cells.
+func (h *room) export(w http.ResponseWriter, r *http.Request) {
+	p := PatternFromBoard(&h.board)
+	p.Name = "Apparently Experiments Game of Life"
+	var body string
+	switch format := r.URL.Query().Get(URI_PARAM_EXPORT); format {
+	case "", formatRLE:
+		body = p.RLE()
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v.rle"`, h.name))
+	case formatPlaintext:
+		body = p.Plaintext()
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v.cells"`, h.name))
+	default:
+		http.Error(w, fmt.Sprintf("unknown export format %q, expected %v or %v", format, formatRLE, formatPlaintext), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := io.WriteString(w, body); err != nil {
+		slog.Error("Failed to export game of life board", "error", err)
+	}
+}
+
+type stampSignals struct {
+	Stamp struct {
+		Pattern string `json:"pattern"`
+		X       uint   `json:"x"`
+		Y       uint   `json:"y"`
+	} `json:"stamp"`
+}
+
+// stamp draws a pattern onto the board for every viewer, sending its tiles through the same path as a click.
+// Datastar requests carry the pattern and position in the stamp signal. Anything else, such as a script,
+// posts the pattern as the body with the position in the x and y parameters.
+func (h *room) stamp(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("game of life stamp()", "request_id", r.Header.Get(shared.RequestIDHeader))
+	if r.Header.Get("Datastar-Request") != "true" {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatternBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		x, errX := strconv.ParseUint(r.URL.Query().Get(URI_PARAM_X), 10, 0)
+		y, errY := strconv.ParseUint(r.URL.Query().Get(URI_PARAM_Y), 10, 0)
+		if errX != nil || errY != nil {
+			http.Error(w, "x and y must be given as the position of the pattern's top left cell", http.StatusBadRequest)
+			return
+		}
+		count, err := h.stampPattern(r, string(body), uint(x), uint(y))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = fmt.Fprintf(w, "stamped %v cells\n", count)
+		return
+	}
+
+	signals := stampSignals{}
+	r.Body = http.MaxBytesReader(w, r.Body, maxPatternBody)
+	err := datastar.ReadSignals(r, &signals)
+	sse := datastar.NewSSE(w, r)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	if _, err := h.stampPattern(r, signals.Stamp.Pattern, signals.Stamp.X, signals.Stamp.Y); err != nil {
+		_ = sse.ConsoleError(err)
+	}
+}
+
+func (h *room) stampPattern(r *http.Request, text string, x, y uint) (int, error) {
+	if x >= boardSizeX || y >= boardSizeY {
+		return 0, fmt.Errorf("position (%v, %v) is off the %vx%v board", x, y, boardSizeX, boardSizeY)
+	}
+	p, err := ParsePattern(text)
+	if err != nil {
+		return 0, err
+	}
+	tiles := p.Stamp(x, y)
+	if len(tiles) == 0 {
+		return 0, nil
+	}
+	return len(tiles), h.publish(r.Context(), replicaMessage{Tiles: tiles})
+}
@@ -0,0 +1,132 @@
+package gameoflife
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, parse func(string) (Pattern, error), text string) Pattern {
+	t.Helper()
+	p, err := parse(text)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", text, err)
+	}
+	return p
+}
+
+func TestParseRLE(t *testing.T) {
+	// The glider as published on the LifeWiki.
+	glider := "#N Glider\n#O Richard K. Guy\n#C The smallest, most common, and first discovered spaceship.\nx = 3, y = 3, rule = B3/S23\nbob$2bo$3o!\n"
+	p := mustParse(t, ParseRLE, glider)
+	want := []Point{{1, 0}, {2, 1}, {0, 2}, {1, 2}, {2, 2}}
+	if p.Name != "Glider" || p.Width != 3 || p.Height != 3 || p.Rule != "B3/S23" || !slices.Equal(p.Cells, want) {
+		t.Errorf("ParseRLE(glider) = %+v, want a 3x3 B3/S23 glider with cells %v", p, want)
+	}
+
+	// Rows may be split over several lines, and a run of $ skips blank rows.
+	p = mustParse(t, ParseRLE, "x = 4, y = 4\n2o\n2b$3$\no!")
+	if want := []Point{{0, 0}, {1, 0}, {0, 4}}; !slices.Equal(p.Cells, want) || p.Height != 5 {
+		t.Errorf("ParseRLE of a split pattern has cells %v and height %v, want %v and 5", p.Cells, p.Height, want)
+	}
+}
+
+func TestParsePlaintext(t *testing.T) {
+	p := mustParse(t, ParsePlaintext, "!Name: Glider\n!\n.O\n..O\nOOO\n")
+	want := []Point{{1, 0}, {2, 1}, {0, 2}, {1, 2}, {2, 2}}
+	if p.Name != "Glider" || p.Width != 3 || p.Height != 3 || !slices.Equal(p.Cells, want) {
+		t.Errorf("ParsePlaintext(glider) = %+v, want a 3x3 glider with cells %v", p, want)
+	}
+}
+
+func TestParsePatternDetectsFormat(t *testing.T) {
+	for _, text := range []string{"x = 2, y = 1\n2o!", "#N Domino\nx = 2, y = 1\n2o!", "OO", "!Name: Domino\nOO\n"} {
+		p, err := ParsePattern(text)
+		if err != nil || !slices.Equal(p.Cells, []Point{{0, 0}, {1, 0}}) {
+			t.Errorf("ParsePattern(%q) = %v, %v, want a domino", text, p.Cells, err)
+		}
+	}
+}
+
+// TestPatternRoundTrip writes patterns out in each format and checks that they read back the same.
+func TestPatternRoundTrip(t *testing.T) {
+	patterns := []Pattern{
+		{Name: "Glider", Width: 3, Height: 3, Rule: "B3/S23", Cells: []Point{{1, 0}, {2, 1}, {0, 2}, {1, 2}, {2, 2}}},
+		// Blank rows in the middle and dead space around the cells, which only the width and height record.
+		{Width: 10, Height: 8, Cells: []Point{{3, 1}, {4, 1}, {9, 6}}},
+		// Long enough runs to need several digits and to wrap the RLE over several lines.
+		{Name: "Line", Width: 200, Height: 2, Rule: "B36/S23", Cells: func() []Point {
+			cells := make([]Point, 0)
+			for x := range uint(200) {
+				if x%3 != 0 || x > 150 {
+					cells = append(cells, Point{X: x, Y: 1})
+				}
+			}
+			return cells
+		}()},
+		{Width: 1, Height: 1},
+	}
+	for _, want := range patterns {
+		p := mustParse(t, ParseRLE, want.RLE())
+		// RLE leaves out trailing dead space, which only the header keeps.
+		if p.Name != want.Name || p.Rule != want.Rule || p.Width != want.Width || p.Height != want.Height || !slices.Equal(p.Cells, want.Cells) {
+			t.Errorf("RLE round trip of %q gave %+v, want %+v", want.RLE(), p, want)
+		}
+		for _, line := range strings.Split(want.RLE(), "\n") {
+			if len(line) > 70 && !strings.HasPrefix(line, "#") {
+				t.Errorf("RLE line %q is longer than 70 characters", line)
+			}
+		}
+
+		p = mustParse(t, ParsePlaintext, want.Plaintext())
+		if p.Name != want.Name || !slices.Equal(p.Cells, want.Cells) || p.Width != want.Width {
+			t.Errorf("plaintext round trip of %q gave %+v, want %+v", want.Plaintext(), p, want)
+		}
+	}
+}
+
+// TestParseRejects covers malformed patterns and patterns too large to accept from a player.
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func(string) (Pattern, error)
+		text  string
+	}{
+		{"RLE without a header", ParseRLE, "#N Nothing\n"},
+		{"RLE header without values", ParseRLE, "x 3, y 3\nooo!"},
+		{"RLE with an unknown rule", ParseRLE, "x = 3, y = 1, rule = B9/S\n3o!"},
+		{"RLE with an unexpected character", ParseRLE, "x = 3, y = 1\no?o!"},
+		{"RLE header too wide", ParseRLE, "x = 4097, y = 1\no!"},
+		{"RLE header too tall", ParseRLE, "x = 1, y = 99999999999999999999\no!"},
+		{"RLE run too long", ParseRLE, "x = 1, y = 1\n4097o!"},
+		{"RLE run with an overflowing count", ParseRLE, "x = 1, y = 1\n18446744073709551617o!"},
+		{"RLE row too wide", ParseRLE, "x = 1, y = 1\n4000b100o!"},
+		{"RLE too tall", ParseRLE, "x = 1, y = 1\n4000$100$o!"},
+		{"RLE too many cells", ParseRLE, "x = 4096, y = 4096\n" + strings.Repeat("4096o$", 100) + "!"},
+		{"plaintext with an unexpected character", ParsePlaintext, "O.O\nOxO\n"},
+		{"plaintext too wide", ParsePlaintext, strings.Repeat(".", 4097)},
+		{"plaintext too tall", ParsePlaintext, strings.Repeat("O\n", 4097)},
+		{"plaintext too many cells", ParsePlaintext, strings.Repeat(strings.Repeat("O", 4096)+"\n", 100)},
+		{"empty pattern", ParsePattern, "\n  \n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.parse(tt.text)
+			if err == nil {
+				t.Fatalf("expected an error, parsed a %vx%v pattern with %v cells", p.Width, p.Height, len(p.Cells))
+			}
+			if len(p.Cells) > maxPatternCells || p.Width > maxPatternSide || p.Height > maxPatternSide {
+				t.Errorf("rejected pattern still grew to %vx%v with %v cells", p.Width, p.Height, len(p.Cells))
+			}
+		})
+	}
+}
+
+func TestStampClipsToTheBoard(t *testing.T) {
+	p := mustParse(t, ParseRLE, "x = 3, y = 3\nbob$2bo$3o!")
+	tiles := p.Stamp(boardSizeX-2, boardSizeY-1)
+	want := []TileUpdate{{X: boardSizeX - 2, Y: boardSizeY - 1}, {X: boardSizeX - 1, Y: boardSizeY - 1, Value: true}}
+	if !slices.Equal(tiles, want) {
+		t.Errorf("Stamp at the corner = %+v, want %+v", tiles, want)
+	}
+}
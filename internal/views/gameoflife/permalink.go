@@ -0,0 +1,174 @@
+package gameoflife
+
+import (
+	"apparently-experiments/internal/shared"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+const URI_PARAM_BOARD = "board"
+const URI_PARAM_SEED = "seed"
+const URI_PARAM_PERMALINK = "permalink"
+
+// newRoomPath opens a room from a permalink or a seed.
+const newRoomPath = "/gameoflife/new"
+
+// encodeBoard writes the whole board as RLE, deflates it and encodes it for a URL.
+func encodeBoard(board *GameBoard) (string, error) {
+	var b bytes.Buffer
+	w, err := flate.NewWriter(&b, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(w, PatternFromBoard(board).RLE()); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b.Bytes()), nil
+}
+
+// decodeBoard reads a board written by encodeBoard.
+func decodeBoard(encoded string) (Pattern, error) {
+	compressed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Pattern{}, fmt.Errorf("board is not valid base64: %w", err)
+	}
+	// Reading one byte past the limit tells a board that is too large apart from one that fits exactly.
+	text, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), maxPatternBody+1))
+	if err != nil {
+		return Pattern{}, fmt.Errorf("board could not be decompressed: %w", err)
+	}
+	if len(text) > maxPatternBody {
+		return Pattern{}, fmt.Errorf("board is larger than %v bytes once decompressed", maxPatternBody)
+	}
+	return ParseRLE(string(text))
+}
+
+// permalink links to a new room starting from the board as it is now, with its rule and topology.
+func permalink(board *GameBoard) (string, error) {
+	encoded, err := encodeBoard(board)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set(URI_PARAM_BOARD, encoded)
+	query.Set(URI_PARAM_TOPOLOGY, board.Topology().String())
+	return newRoomPath + "?" + query.Encode(), nil
+}
+
+// readStart works out the board a new room starts from, either the board in a permalink or one drawn from a seed.
+func readStart(r *http.Request) (*replicaMessage, error) {
+	query := r.URL.Query()
+	switch {
+	case query.Has(URI_PARAM_BOARD):
+		p, err := decodeBoard(query.Get(URI_PARAM_BOARD))
+		if err != nil {
+			return nil, err
+		}
+		board := [boardSizeX][boardSizeY]bool{}
+		for _, tile := range p.Stamp(0, 0) {
+			board[tile.X][tile.Y] = tile.Value
+		}
+		settings := Settings{Rule: p.Rule}
+		if query.Has(URI_PARAM_TOPOLOGY) {
+			topology, err := ParseTopology(query.Get(URI_PARAM_TOPOLOGY))
+			if err != nil {
+				return nil, err
+			}
+			settings.Topology = topology.String()
+		}
+		return &replicaMessage{Board: packBoard(&board), Settings: settings}, nil
+
+	case query.Has(URI_PARAM_SEED):
+		seed, err := strconv.ParseInt(query.Get(URI_PARAM_SEED), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("seed must be a number: %w", err)
+		}
+		density, err := readDensity(r)
+		if err != nil {
+			return nil, err
+		}
+		board := randomBoard(seed, density)
+		return &replicaMessage{Board: packBoard(&board)}, nil
+
+	default:
+		return nil, fmt.Errorf("a new room needs a %v from a permalink or a %v", URI_PARAM_BOARD, URI_PARAM_SEED)
+	}
+}
+
+// linkRoomID names the room a permalink or seed opens after the board and settings it starts from, so following the
+// same link again, as crawlers do, comes back to the room it opened rather than filling the server with copies.
+func linkRoomID(start *replicaMessage) string {
+	sum := sha256.New()
+	sum.Write(start.Board)
+	fmt.Fprintf(sum, "\n%v\n%v", start.Settings.Rule, start.Settings.Topology)
+	return "link-" + hex.EncodeToString(sum.Sum(nil))[:16]
+}
+
+// New opens a room from a permalink or a seed, or finds the one the same link opened before, and sends the player there.
+func (h *Handler) New() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("game of life new()", "request_id", r.Header.Get(shared.RequestIDHeader))
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		start, err := readStart(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rm, err := h.open(linkRoomID(start), start)
+		if errors.Is(err, errTooManyRooms) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.redirect(w, r, rm.basePath)
+	})
+}
+
+// sharePermalink answers with a permalink to the board as it is now. Datastar requests get it as a link on the page,
+// and anything else as plain text.
+func (h *room) sharePermalink(w http.ResponseWriter, r *http.Request) {
+	link, err := permalink(&h.board)
+	if r.Header.Get("Datastar-Request") != "true" {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = fmt.Fprintln(w, link)
+		return
+	}
+
+	sse := datastar.NewSSE(w, r)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	if err := sse.PatchElementTempl(Permalink(link)); err != nil {
+		_ = sse.ConsoleError(err)
+	}
+}
+
+func permalinkExpression(basePath string) string {
+	return fmt.Sprintf("@get('%v?%v')", basePath, URI_PARAM_PERMALINK)
+}
@@ -0,0 +1,196 @@
+package gameoflife
+
+import (
+	"apparently-experiments/internal/broker"
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startBoard(t *testing.T, start *replicaMessage) [boardSizeX][boardSizeY]bool {
+	t.Helper()
+	board, err := unpackBoard(start.Board)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return board
+}
+
+func TestPermalinkRoundTrip(t *testing.T) {
+	gb := NewGameBoard()
+	gb.SetBoard(randomBoard(7, 30))
+	if err := gb.Apply(Settings{Rule: "B36/S23", Topology: KleinBottle.String()}); err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := permalink(&gb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, newRoomPath+"?") {
+		t.Fatalf("permalink %q does not open a new room", link)
+	}
+	start, err := readStart(httptest.NewRequest("GET", link, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := startBoard(t, start); got != gb.board {
+		t.Error("the board read back from the permalink differs from the one shared")
+	}
+	if want := gb.Settings(); start.Settings != want {
+		t.Errorf("permalink settings are %+v, want %+v", start.Settings, want)
+	}
+	if start.Provisional {
+		t.Error("a room started from a permalink should not give way to other replicas' boards")
+	}
+}
+
+func TestReadStartFromSeed(t *testing.T) {
+	first, err := readStart(httptest.NewRequest("GET", newRoomPath+"?seed=42&density=30", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := readStart(httptest.NewRequest("GET", newRoomPath+"?seed=42&density=30", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if startBoard(t, first) != startBoard(t, again) || startBoard(t, first) != randomBoard(42, 30) {
+		t.Error("the same seed and density should always draw the same board")
+	}
+}
+
+func TestReadStartRejects(t *testing.T) {
+	deflate := func(text string) string {
+		var b bytes.Buffer
+		w, _ := flate.NewWriter(&b, flate.BestCompression)
+		_, _ = w.Write([]byte(text))
+		_ = w.Close()
+		return base64.RawURLEncoding.EncodeToString(b.Bytes())
+	}
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"nothing to start from", url.Values{}},
+		{"board that is not base64", url.Values{URI_PARAM_BOARD: {"not base64!"}}},
+		{"board that is not deflated", url.Values{URI_PARAM_BOARD: {base64.RawURLEncoding.EncodeToString([]byte("x = 1, y = 1\no!"))}}},
+		{"board that is not RLE", url.Values{URI_PARAM_BOARD: {deflate("hello")}}},
+		// A megabyte of RLE comments deflates to next to nothing.
+		{"board too large once decompressed", url.Values{URI_PARAM_BOARD: {deflate(strings.Repeat("#C padding\n", maxPatternBody/10) + "x = 1, y = 1\no!")}}},
+		{"unknown topology", url.Values{URI_PARAM_BOARD: {deflate("x = 1, y = 1\no!")}, URI_PARAM_TOPOLOGY: {"sphere"}}},
+		{"seed that is not a number", url.Values{URI_PARAM_SEED: {"lucky"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readStart(httptest.NewRequest("GET", newRoomPath+"?"+tt.query.Encode(), nil)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// TestLinkedRoomOnAnotherReplica opens a room from a permalink on one replica and then the same room on another,
+// which has never seen the permalink. The second must take the first's board rather than keep a random one.
+func TestLinkedRoomOnAnotherReplica(t *testing.T) {
+	b := broker.NewMemory()
+	defer b.Close()
+
+	linked := randomBoard(3, 40)
+	first, err := newRoom("gameoflife:link-test", "/gameoflife/link-test", b, &replicaMessage{Board: packBoard(&linked)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.close()
+	second, err := newRoom("gameoflife:link-test", "/gameoflife/link-test", b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshot := second.board.Snapshot()
+		if snapshot.board == linked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the second replica never took the linked board")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if snapshot := first.board.Snapshot(); snapshot.board != linked {
+		t.Error("the first replica gave up the linked board")
+	}
+}
+
+func TestAdoptPrefersGivenBoards(t *testing.T) {
+	board := randomBoard(5, 50)
+	packed := packBoard(&board)
+	tests := []struct {
+		name        string
+		provisional bool
+		generation  uint64
+		msg         replicaMessage
+		want        bool
+	}{
+		{"provisional takes a given board from behind", true, 500, replicaMessage{Origin: "z", Generation: 1, Board: packed}, true},
+		{"given board ignores a provisional one from ahead", false, 1, replicaMessage{Origin: "a", Generation: 500, Board: packed, Provisional: true}, false},
+		{"provisional takes a provisional board from ahead", true, 1, replicaMessage{Origin: "z", Generation: 2, Board: packed, Provisional: true}, true},
+		{"given board takes a given board from ahead", false, 1, replicaMessage{Origin: "z", Generation: 2, Board: packed}, true},
+		{"given board keeps itself against one from behind", false, 2, replicaMessage{Origin: "a", Generation: 1, Board: packed}, false},
+		{"ties go to the lower origin", false, 2, replicaMessage{Origin: "a", Generation: 2, Board: packed}, true},
+		{"ties stay with the lower origin", false, 2, replicaMessage{Origin: "z", Generation: 2, Board: packed}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &room{board: NewGameBoard(), origin: "m", generation: tt.generation, provisional: tt.provisional}
+			if got := h.adopt(&tt.msg); got != tt.want {
+				t.Fatalf("adopt = %v, want %v", got, tt.want)
+			}
+			if tt.want && (h.generation != tt.msg.Generation || h.provisional != tt.msg.Provisional) {
+				t.Errorf("after adopting the room is at generation %v, provisional %v", h.generation, h.provisional)
+			}
+		})
+	}
+}
+
+// TestNewReusesTheLinkedRoom follows links the way a crawler would, which must not open a room per visit.
+func TestNewReusesTheLinkedRoom(t *testing.T) {
+	h := &Handler{broker: broker.NewMemory(), maxRooms: 2, rooms: make(map[string]*room)}
+	t.Cleanup(func() {
+		for _, rm := range h.rooms {
+			rm.close()
+		}
+	})
+	follow := func(query string) (int, string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.New().ServeHTTP(w, httptest.NewRequest("GET", newRoomPath+"?"+query, nil))
+		return w.Code, w.Header().Get("Location")
+	}
+
+	_, first := follow("seed=42&density=30")
+	_, again := follow("seed=42&density=30")
+	if first == "" || again != first {
+		t.Fatalf("the same link led to %q and then %q, want the same room", first, again)
+	}
+	if _, other := follow("seed=43&density=30"); other == first {
+		t.Errorf("a different seed led to the same room %q", other)
+	}
+	if len(h.rooms) != 2 {
+		t.Fatalf("%v rooms are open after following two links three times, want 2", len(h.rooms))
+	}
+	// Once the rooms are full, links to boards already open still work.
+	if code, _ := follow("seed=44&density=30"); code != http.StatusServiceUnavailable {
+		t.Errorf("a new link with every room taken answered %v, want %v", code, http.StatusServiceUnavailable)
+	}
+	if code, location := follow("seed=42&density=30"); code != http.StatusSeeOther || location != first {
+		t.Errorf("the first link answered %v to %q once the rooms were full, want a redirect to %q", code, location, first)
+	}
+}
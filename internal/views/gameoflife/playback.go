@@ -0,0 +1,214 @@
+package gameoflife
+
+import (
+	"apparently-experiments/internal/shared"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+const URI_PARAM_CONTROL = "control"
+const URI_PARAM_SPEED = "speed"
+const URI_PARAM_DENSITY = "density"
+
+// Action is one of the playback controls shared by everyone watching the board.
+type Action string
+
+const (
+	ActionPlay      Action = "play"
+	ActionPause     Action = "pause"
+	ActionStep      Action = "step"
+	ActionSpeed     Action = "speed"
+	ActionClear     Action = "clear"
+	ActionRandomize Action = "randomize"
+	ActionSeek      Action = "seek"
+	// ActionAutoReseed turns replacing boards that have stabilized with a random one on or off.
+	ActionAutoReseed Action = "autoreseed"
+)
+
+const (
+	// Speeds are in generations per second. As a generation takes a whole number of ticks, a speed that does not
+	// divide ticksPerSecond plays at the fastest rate below it that does fit, so 3 plays at 2.5 and 4 at 3.3.
+	minSpeed     = 1
+	maxSpeed     = ticksPerSecond
+	defaultSpeed = 1
+	// The share of cells alive in a randomized board, in percent.
+	defaultDensity = 50
+)
+
+// Controls is the playback state of the board, shown to every viewer.
+type Controls struct {
+	Paused     bool `json:"paused"`
+	Speed      int  `json:"speed"`
+	AutoReseed bool `json:"autoReseed"`
+}
+
+func defaultControls() Controls {
+	return Controls{Speed: defaultSpeed}
+}
+
+// tickRate is the number of ticks skipped between generations while playing.
+func (c Controls) tickRate() uint {
+	// Rounding the ticks per generation up means a speed is never played faster than it was set.
+	return uint((ticksPerSecond+c.Speed-1)/c.Speed) - 1
+}
+
+// generationsPerSecond is the speed the board is actually played at, shown next to the speed control.
+func (c Controls) generationsPerSecond() float64 {
+	return float64(ticksPerSecond) / float64(c.tickRate()+1)
+}
+
+// Command changes the playback of every replica's board.
+type Command struct {
+	Action Action `json:"action"`
+	Speed  int    `json:"speed,omitempty"`
+	// Density is the percentage of cells alive after randomizing.
+	Density int `json:"density,omitempty"`
+	// Seed is picked by the replica that received the command, so every replica randomizes the same board.
+	Seed int64 `json:"seed,omitempty"`
+	// Generation is the past generation to seek to.
+	Generation uint64 `json:"generation,omitempty"`
+	// Enabled turns auto reseeding on or off.
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// readCommand validates the command in the request's parameters.
+func readCommand(r *http.Request) (Command, error) {
+	cmd := Command{Action: Action(r.URL.Query().Get(URI_PARAM_CONTROL))}
+	switch cmd.Action {
+	case ActionPlay, ActionPause, ActionStep, ActionClear:
+	case ActionSpeed:
+		speed, err := strconv.Atoi(r.URL.Query().Get(URI_PARAM_SPEED))
+		if err != nil {
+			return cmd, fmt.Errorf("speed must be a number of generations per second: %w", err)
+		}
+		cmd.Speed = min(max(speed, minSpeed), maxSpeed)
+	case ActionRandomize:
+		density, err := readDensity(r)
+		if err != nil {
+			return cmd, err
+		}
+		cmd.Density = density
+		cmd.Seed = rand.Int63()
+		// Giving a seed draws the same board every time.
+		if r.URL.Query().Get(URI_PARAM_SEED) != "" {
+			if cmd.Seed, err = strconv.ParseInt(r.URL.Query().Get(URI_PARAM_SEED), 10, 64); err != nil {
+				return cmd, fmt.Errorf("seed must be a number: %w", err)
+			}
+		}
+	case ActionSeek:
+		generation, err := strconv.ParseUint(r.URL.Query().Get(URI_PARAM_GENERATION), 10, 64)
+		if err != nil {
+			return cmd, fmt.Errorf("generation must be a generation number: %w", err)
+		}
+		cmd.Generation = generation
+	case ActionAutoReseed:
+		enabled, err := strconv.ParseBool(r.URL.Query().Get(URI_PARAM_ENABLED))
+		if err != nil {
+			return cmd, fmt.Errorf("enabled must be true or false: %w", err)
+		}
+		cmd.Enabled = enabled
+	default:
+		return cmd, fmt.Errorf("unknown control %q, expected one of play, pause, step, speed, clear, randomize, seek or autoreseed", cmd.Action)
+	}
+	return cmd, nil
+}
+
+// readDensity reads the share of cells alive in a random board, defaulting to defaultDensity.
+func readDensity(r *http.Request) (int, error) {
+	if !r.URL.Query().Has(URI_PARAM_DENSITY) {
+		return defaultDensity, nil
+	}
+	density, err := strconv.Atoi(r.URL.Query().Get(URI_PARAM_DENSITY))
+	if err != nil {
+		return 0, fmt.Errorf("density must be a percentage: %w", err)
+	}
+	return min(max(density, 0), 100), nil
+}
+
+// randomBoard fills each cell with the given percentage chance, the same way for the same seed.
+func randomBoard(seed int64, density int) [boardSizeX][boardSizeY]bool {
+	random := rand.New(rand.NewSource(seed))
+	board := [boardSizeX][boardSizeY]bool{}
+	for y := range boardSizeY {
+		for x := range boardSizeX {
+			board[x][y] = random.Intn(100) < density
+		}
+	}
+	return board
+}
+
+// control sends a playback command to every replica. Like changeSettings, datastar requests are answered over SSE
+// and anything else with a plain status.
+func (h *room) control(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("game of life control()", "request_id", r.Header.Get(shared.RequestIDHeader))
+	cmd, err := readCommand(r)
+	if r.Header.Get("Datastar-Request") != "true" {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.publish(r.Context(), replicaMessage{Command: &cmd}); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	sse := datastar.NewSSE(w, r)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	if err := h.publish(r.Context(), replicaMessage{Command: &cmd}); err != nil {
+		_ = sse.ConsoleError(err)
+	}
+}
+
+// command applies a playback command to the board. It must only be called from the serve() worker.
+func (h *room) command(cmd Command) {
+	slog.Info("Game of life playback", "action", cmd.Action)
+	switch cmd.Action {
+	case ActionPlay:
+		h.controls.Paused = false
+		h.setTickRate(h.controls.tickRate())
+	case ActionPause:
+		h.controls.Paused = true
+	case ActionStep:
+		// Stepping only makes sense while paused, so the board stays where the player left it.
+		h.controls.Paused = true
+		h.advance()
+		return
+	case ActionSpeed:
+		h.controls.Speed = min(max(cmd.Speed, minSpeed), maxSpeed)
+		h.setTickRate(h.controls.tickRate())
+	case ActionClear:
+		h.board.SetBoard([boardSizeX][boardSizeY]bool{})
+	case ActionRandomize:
+		h.board.SetBoard(randomBoard(cmd.Seed, cmd.Density))
+	case ActionSeek:
+		h.seek(cmd.Generation)
+	case ActionAutoReseed:
+		h.controls.AutoReseed = cmd.Enabled
+	}
+	h.broadcast()
+}
+
+// The speed slider posts when released rather than while it is dragged.
+func speedExpression(basePath string) string {
+	return fmt.Sprintf("@post('%v?%v=%v&%v=' + evt.target.value)", basePath, URI_PARAM_CONTROL, ActionSpeed, URI_PARAM_SPEED)
+}
+
+func controlExpression(basePath string, action Action) string {
+	return fmt.Sprintf("@post('%v?%v=%v')", basePath, URI_PARAM_CONTROL, action)
+}
+
+func randomizeExpression(basePath string) string {
+	return fmt.Sprintf("@post('%v?%v=%v&%v=' + $_density + '&%v=' + encodeURIComponent($_seed))",
+		basePath, URI_PARAM_CONTROL, ActionRandomize, URI_PARAM_DENSITY, URI_PARAM_SEED)
+}
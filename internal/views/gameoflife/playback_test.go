@@ -0,0 +1,17 @@
+package gameoflife
+
+import "testing"
+
+func TestTickRateNeverPlaysFasterThanTheSpeed(t *testing.T) {
+	// The generations per second actually played at each speed, with a tick every tickDurationMS.
+	want := map[int]float64{1: 1, 2: 2, 3: 2.5, 4: 10.0 / 3, 5: 5, 6: 5, 7: 5, 8: 5, 9: 5, 10: 10}
+	for speed := minSpeed; speed <= maxSpeed; speed++ {
+		got := Controls{Speed: speed}.generationsPerSecond()
+		if got != want[speed] {
+			t.Errorf("speed %v plays %.2f generations per second, want %.2f", speed, got, want[speed])
+		}
+		if got > float64(speed) {
+			t.Errorf("speed %v plays faster than it was set, at %.2f generations per second", speed, got)
+		}
+	}
+}
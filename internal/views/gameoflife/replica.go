@@ -0,0 +1,158 @@
+package gameoflife
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+// replicaMessage is the envelope exchanged with other replicas through the broker, on a topic named after the room.
+// Either Tile is set for a player's edit, Tiles for a stamped pattern, Command for a playback control,
+// Settings alone are set when someone changes them, Board holds a whole generation after a tick
+// along with the Settings it is played under, or Sync asks the other replicas for their boards.
+type replicaMessage struct {
+	Origin     string       `json:"origin"`
+	Tile       *TileUpdate  `json:"tile,omitempty"`
+	Tiles      []TileUpdate `json:"tiles,omitempty"`
+	Command    *Command     `json:"command,omitempty"`
+	Generation uint64       `json:"generation,omitempty"`
+	Board      []byte       `json:"board,omitempty"`
+	Settings   Settings     `json:"settings,omitzero"`
+	// Provisional marks a board that was made up by a replica when it opened the room, rather than one the room
+	// was started from or has since adopted.
+	Provisional bool `json:"provisional,omitempty"`
+	Sync        bool `json:"sync,omitempty"`
+	// resync is set locally when the broker reports that messages may have been missed. It is never sent.
+	resync bool
+}
+
+func (h *room) publish(ctx context.Context, msg replicaMessage) error {
+	msg.Origin = h.origin
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return h.broker.Publish(ctx, h.name, payload)
+}
+
+// receive decodes messages from the broker and hands them to the serve() worker.
+// Tile edits from every replica, this one included, arrive through the same tx channel.
+// It returns once the room is closed, which also closes the subscription.
+func (h *room) receive(updates <-chan []byte) {
+	for payload := range updates {
+		var msg replicaMessage
+		if payload == nil {
+			msg.resync = true
+		} else if err := json.Unmarshal(payload, &msg); err != nil {
+			slog.Error("Discarding malformed game of life replica message", "error", err)
+			continue
+		}
+		delivered := true
+		switch {
+		case msg.Tile != nil:
+			delivered = deliver(h.ctx, h.tx, msg.Tile)
+		case msg.Tiles != nil:
+			for i := range msg.Tiles {
+				if delivered = deliver(h.ctx, h.tx, &msg.Tiles[i]); !delivered {
+					break
+				}
+			}
+		case msg.Command != nil:
+			delivered = deliver(h.ctx, h.commands, *msg.Command)
+		case msg.resync || (msg.Board != nil || msg.Sync) && msg.Origin != h.origin:
+			delivered = deliver(h.ctx, h.remote, &msg)
+		case msg.Board == nil && msg.Settings != (Settings{}):
+			delivered = deliver(h.ctx, h.settings, msg.Settings)
+		}
+		if !delivered {
+			return
+		}
+	}
+	if h.ctx.Err() == nil {
+		slog.Warn("Game of life broker subscription closed", "room", h.name)
+	}
+}
+
+// deliver hands msg to the serve() worker, giving up if the room is closed before it is taken.
+func deliver[T any](ctx context.Context, ch chan<- T, msg T) bool {
+	select {
+	case ch <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// adopt replaces the local board with a peer's if the peer is further along.
+// Replicas tick independently, so ties are broken by origin to make every replica settle on the same board,
+// after which the deterministic rules keep them in step. A provisional board always gives way to one that is not,
+// whatever their generations, so a room started from a permalink or a fork on one replica is not overrun by the
+// random board another replica makes up when a player lands there. It must only be called from the serve() worker.
+func (h *room) adopt(msg *replicaMessage) bool {
+	switch {
+	case h.provisional && !msg.Provisional:
+	case msg.Provisional && !h.provisional:
+		return false
+	case msg.Generation < h.generation || (msg.Generation == h.generation && msg.Origin >= h.origin):
+		return false
+	}
+	board, err := unpackBoard(msg.Board)
+	if err != nil {
+		slog.Error("Discarding game of life board from replica", "origin", msg.Origin, "error", err)
+		return false
+	}
+	if err := h.board.Apply(msg.Settings); err != nil {
+		slog.Error("Discarding game of life board from replica", "origin", msg.Origin, "error", err)
+		return false
+	}
+	h.board.SetBoard(board)
+	h.generation = msg.Generation
+	h.provisional = msg.Provisional
+	return true
+}
+
+// sync answers another replica that has just opened the room with the board here, unless that is provisional too.
+// It must only be called from the serve() worker.
+func (h *room) sync() {
+	if h.provisional {
+		return
+	}
+	snapshot := h.board.Snapshot()
+	err := h.publish(h.ctx, replicaMessage{
+		Generation: h.generation,
+		Board:      packBoard(&snapshot.board),
+		Settings:   snapshot.Settings(),
+	})
+	if err != nil {
+		slog.Error("Failed to send game of life board to replica", "room", h.name, "error", err)
+	}
+}
+
+// packBoard stores the board one bit per cell, row by row.
+func packBoard(board *[boardSizeX][boardSizeY]bool) []byte {
+	packed := make([]byte, (boardSizeX*boardSizeY+7)/8)
+	for y := range boardSizeY {
+		for x := range boardSizeX {
+			if board[x][y] {
+				i := y*boardSizeX + x
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+	}
+	return packed
+}
+
+func unpackBoard(packed []byte) ([boardSizeX][boardSizeY]bool, error) {
+	board := [boardSizeX][boardSizeY]bool{}
+	if len(packed) != (boardSizeX*boardSizeY+7)/8 {
+		return board, fmt.Errorf("packed board is %v bytes, expected %v", len(packed), (boardSizeX*boardSizeY+7)/8)
+	}
+	for y := range boardSizeY {
+		for x := range boardSizeX {
+			i := y*boardSizeX + x
+			board[x][y] = packed[i/8]&(1<<(i%8)) != 0
+		}
+	}
+	return board, nil
+}
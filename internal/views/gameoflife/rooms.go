@@ -0,0 +1,212 @@
+package gameoflife
+
+import (
+	"apparently-experiments/internal/broker"
+	"apparently-experiments/internal/shared"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+const URI_PARAM_ROOM string = "room"
+
+const (
+	// Rooms nobody has watched or used for this long are shut down, and start over with a new board on their next visit.
+	roomIdleTimeout     = 10 * time.Minute
+	roomCollectInterval = time.Minute
+	// The number of rooms held in memory at once when the server does not configure it.
+	DefaultMaxRooms = 32
+	// How often the lobby refreshes its list of rooms.
+	lobbyRefresh = 2 * time.Second
+)
+
+var roomIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var errTooManyRooms = errors.New("too many game of life rooms are open, please try again later")
+
+// Handler serves the game of life rooms. /gameoflife is the original shared board, which is never shut down,
+// and /gameoflife/{room} creates a separate board with its own ticker and viewers on first visit.
+type Handler struct {
+	broker   broker.Broker
+	maxRooms int
+	mu       sync.Mutex
+	rooms    map[string]*room
+}
+
+// NewHandler creates the game of life demo with at most maxRooms rooms open at once, the original board included.
+// Edits and generations are published through b so that every replica sharing it shows the same boards.
+func NewHandler(b broker.Broker, maxRooms int) *Handler {
+	if maxRooms < 1 {
+		maxRooms = DefaultMaxRooms
+	}
+	h := &Handler{
+		broker:   b,
+		maxRooms: maxRooms,
+		rooms:    make(map[string]*room),
+	}
+	rm, err := newRoom(roomName(""), roomPath(""), b, nil)
+	if err != nil {
+		panic(err)
+	}
+	h.rooms[""] = rm
+	go h.collect()
+	return h
+}
+
+// roomName identifies a room in broker topics, hubs and metrics.
+func roomName(id string) string {
+	if id == "" {
+		return "gameoflife"
+	}
+	return "gameoflife-" + id
+}
+
+func roomPath(id string) string {
+	if id == "" {
+		return "/gameoflife"
+	}
+	return "/gameoflife/" + id
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(URI_PARAM_ROOM)
+	if id != "" && !roomIDPattern.MatchString(id) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	rm, err := h.room(id)
+	if errors.Is(err, errTooManyRooms) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to open game of life room", "room", id, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPost && r.URL.Query().Has(URI_PARAM_FORK) {
+		h.fork(w, r, rm)
+		return
+	}
+	rm.ServeHTTP(w, r)
+}
+
+// room returns the room for id, creating it if this is its first visit.
+func (h *Handler) room(id string) (*room, error) {
+	return h.open(id, nil)
+}
+
+// open returns the room for id, creating it from the board in start if it is not open yet.
+func (h *Handler) open(id string, start *replicaMessage) (*room, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if rm, ok := h.rooms[id]; ok {
+		rm.touch()
+		return rm, nil
+	}
+	return h.add(id, start)
+}
+
+// add creates the room for id. It must be called with mu held.
+func (h *Handler) add(id string, start *replicaMessage) (*room, error) {
+	if len(h.rooms) >= h.maxRooms {
+		return nil, errTooManyRooms
+	}
+	rm, err := newRoom(roomName(id), roomPath(id), h.broker, start)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Opened game of life room", "room", id)
+	h.rooms[id] = rm
+	return rm, nil
+}
+
+// collect periodically shuts down rooms that have gone idle.
+func (h *Handler) collect() {
+	ticker := time.NewTicker(roomCollectInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.mu.Lock()
+		for id, rm := range h.rooms {
+			if id == "" || !rm.idle(roomIdleTimeout) {
+				continue
+			}
+			slog.Info("Closing idle game of life room", "room", id)
+			rm.close()
+			delete(h.rooms, id)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// RoomSummary is a room as listed in the lobby.
+type RoomSummary struct {
+	Path       string
+	Name       string
+	Population int
+	Viewers    int
+}
+
+// summaries lists the open rooms, the original board first and the rest by name.
+func (h *Handler) summaries() []RoomSummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	summaries := make([]RoomSummary, 0, len(h.rooms))
+	for id, rm := range h.rooms {
+		summaries = append(summaries, RoomSummary{
+			Path:       rm.basePath,
+			Name:       id,
+			Population: rm.board.Population(),
+			Viewers:    rm.presence.Count(),
+		})
+	}
+	// The original board has the empty name, so it sorts first.
+	slices.SortFunc(summaries, func(a, b RoomSummary) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return summaries
+}
+
+// Lobby lists the open rooms with their population and viewers, and lets players open a new one.
+func (h *Handler) Lobby() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Query().Has("listen") {
+			h.listenLobby(w, r)
+			return
+		}
+		templ.Handler(Lobby(h.summaries(), h.maxRooms)).ServeHTTP(w, r)
+	})
+}
+
+// listenLobby keeps the list of rooms up to date while the lobby is open.
+func (h *Handler) listenLobby(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Header.Get(shared.RequestIDHeader)
+	slog.Debug("game of life lobby listen()", "request_id", requestId)
+	sse := datastar.NewSSE(w, r)
+	ticker := time.NewTicker(lobbyRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sse.Context().Done():
+			slog.Debug("game of life lobby listener disconnected", "request_id", requestId)
+			return
+		case <-ticker.C:
+			if err := sse.PatchElementTempl(RoomList(h.summaries(), h.maxRooms)); err != nil {
+				slog.Error("Error occurred when patching", "error", err)
+			}
+		}
+	}
+}
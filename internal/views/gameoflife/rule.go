@@ -0,0 +1,111 @@
+package gameoflife
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rule is a Life-like rule: a dead cell is born when its number of live neighbours is in Birth,
+// and a live cell survives when it is in Survive. Bit n of each mask stands for n neighbours.
+type Rule struct {
+	Birth   uint16
+	Survive uint16
+}
+
+// Conway is the standard Game of Life, B3/S23.
+var Conway = Rule{Birth: 1 << 3, Survive: 1<<2 | 1<<3}
+
+// NamedRule is a well known rule offered in the board's rule picker.
+type NamedRule struct {
+	Name string
+	Rule string
+}
+
+var namedRules = []NamedRule{
+	{"Conway's Life", "B3/S23"},
+	{"HighLife", "B36/S23"},
+	{"Seeds", "B2/S"},
+	{"Day & Night", "B3678/S34678"},
+	{"Life without Death", "B3/S012345678"},
+	{"Maze", "B3/S12345"},
+	{"Replicator", "B1357/S1357"},
+	{"2x2", "B36/S125"},
+	{"Diamoeba", "B35678/S5678"},
+	{"Morley", "B368/S245"},
+}
+
+func isNamedRule(rule Rule) bool {
+	for _, named := range namedRules {
+		if named.Rule == rule.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRule reads a rule in B/S notation such as B3/S23, case insensitively.
+// The older S/B notation without letters, e.g. 23/3 for Conway's Life, is accepted too.
+func ParseRule(s string) (Rule, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	first, second, found := strings.Cut(s, "/")
+	if !found {
+		return Rule{}, fmt.Errorf("rule %q is not in B/S notation", s)
+	}
+
+	var birth, survive string
+	switch {
+	case strings.HasPrefix(first, "B") && strings.HasPrefix(second, "S"):
+		birth, survive = first[1:], second[1:]
+	case strings.HasPrefix(first, "S") && strings.HasPrefix(second, "B"):
+		birth, survive = second[1:], first[1:]
+	default:
+		birth, survive = second, first
+	}
+
+	var rule Rule
+	var err error
+	if rule.Birth, err = parseCounts(birth); err != nil {
+		return Rule{}, fmt.Errorf("rule %q has invalid births: %w", s, err)
+	}
+	if rule.Survive, err = parseCounts(survive); err != nil {
+		return Rule{}, fmt.Errorf("rule %q has invalid survivals: %w", s, err)
+	}
+	return rule, nil
+}
+
+func parseCounts(digits string) (uint16, error) {
+	var mask uint16
+	for _, digit := range digits {
+		if digit < '0' || digit > '8' {
+			return 0, fmt.Errorf("%q is not a neighbour count between 0 and 8", digit)
+		}
+		mask |= 1 << (digit - '0')
+	}
+	return mask, nil
+}
+
+// String writes the rule in B/S notation.
+func (r Rule) String() string {
+	var b strings.Builder
+	b.WriteByte('B')
+	for n := range 9 {
+		if r.Birth&(1<<n) != 0 {
+			b.WriteByte(byte('0' + n))
+		}
+	}
+	b.WriteString("/S")
+	for n := range 9 {
+		if r.Survive&(1<<n) != 0 {
+			b.WriteByte(byte('0' + n))
+		}
+	}
+	return b.String()
+}
+
+// Next returns whether a cell is alive in the next generation.
+func (r Rule) Next(alive bool, neighbours int) bool {
+	if alive {
+		return r.Survive&(1<<neighbours) != 0
+	}
+	return r.Birth&(1<<neighbours) != 0
+}
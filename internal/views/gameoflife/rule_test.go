@@ -0,0 +1,151 @@
+package gameoflife
+
+import (
+	"testing"
+)
+
+// pattern places a drawing of live ('o') and dead ('.') cells on an empty board with its top left at (x0, y0).
+func pattern(x0, y0 uint, rows ...string) [boardSizeX][boardSizeY]bool {
+	board := [boardSizeX][boardSizeY]bool{}
+	for dy, row := range rows {
+		for dx, c := range row {
+			if c == 'o' {
+				board[x0+uint(dx)][y0+uint(dy)] = true
+			}
+		}
+	}
+	return board
+}
+
+func mustParseRule(t *testing.T, s string) Rule {
+	t.Helper()
+	rule, err := ParseRule(s)
+	if err != nil {
+		t.Fatalf("ParseRule(%q): %v", s, err)
+	}
+	return rule
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"B3/S23", "B3/S23"},
+		{"b36/s23", "B36/S23"},
+		{"B2/S", "B2/S"},
+		{"S23/B3", "B3/S23"},
+		{"23/3", "B3/S23"},
+		{" B3678/S34678 ", "B3678/S34678"},
+		{"B/S", "B/S"},
+	}
+	for _, tt := range tests {
+		rule := mustParseRule(t, tt.in)
+		if got := rule.String(); got != tt.want {
+			t.Errorf("ParseRule(%q).String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if rule := mustParseRule(t, "B3/S23"); rule != Conway {
+		t.Errorf("ParseRule(B3/S23) = %+v, want Conway %+v", rule, Conway)
+	}
+
+	for _, in := range []string{"", "B3S23", "B9/S23", "B3/Sx", "life"} {
+		if _, err := ParseRule(in); err == nil {
+			t.Errorf("ParseRule(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestNamedRulesParse(t *testing.T) {
+	for _, named := range namedRules {
+		rule := mustParseRule(t, named.Rule)
+		if rule.String() != named.Rule {
+			t.Errorf("%v: %q does not round trip, got %q", named.Name, named.Rule, rule.String())
+		}
+	}
+}
+
+// TestRuleConformance steps known patterns and checks that each one reaches its expected state.
+// Oscillators return to where they started after their period, and spaceships reappear displaced by (dx, dy).
+func TestRuleConformance(t *testing.T) {
+	tests := []struct {
+		name        string
+		rule        string
+		start       []string
+		generations int
+		dx, dy      int
+		// want is the expected board, drawn at (dx, dy), when the pattern is not simply the start shifted by (dx, dy).
+		want []string
+	}{
+		{name: "block", rule: "B3/S23", start: []string{"oo", "oo"}, generations: 1},
+		{name: "blinker", rule: "B3/S23", start: []string{"ooo"}, generations: 2},
+		{name: "blinker phase", rule: "B3/S23", start: []string{"...", "ooo", "..."}, generations: 1,
+			want: []string{".o.", ".o.", ".o."}},
+		{name: "toad", rule: "B3/S23", start: []string{".ooo", "ooo."}, generations: 2},
+		{name: "beacon", rule: "B3/S23", start: []string{"oo..", "oo..", "..oo", "..oo"}, generations: 2},
+		{name: "glider", rule: "B3/S23", start: []string{".o.", "..o", "ooo"}, generations: 4, dx: 1, dy: 1},
+		{name: "lwss", rule: "B3/S23", start: []string{".o..o", "o....", "o...o", "oooo."}, generations: 4, dx: -2},
+
+		{name: "highlife block", rule: "B36/S23", start: []string{"oo", "oo"}, generations: 1},
+		{name: "highlife blinker", rule: "B36/S23", start: []string{"ooo"}, generations: 2},
+		{name: "highlife glider", rule: "B36/S23", start: []string{".o.", "..o", "ooo"}, generations: 4, dx: 1, dy: 1},
+
+		// Nothing survives in Seeds, so a domino immediately splits in two.
+		{name: "seeds domino", rule: "B2/S", start: []string{"..", "..", "oo", "..", ".."}, generations: 1,
+			want: []string{"..", "oo", "..", "oo", ".."}},
+		{name: "seeds domino spreads", rule: "B2/S", start: []string{"..", "..", "oo", "..", ".."}, generations: 2,
+			dx: -1, want: []string{".oo.", "....", "o..o", "....", ".oo."}},
+
+		{name: "day and night block", rule: "B3678/S34678", start: []string{"oo", "oo"}, generations: 1},
+		// A blinker has too few neighbours to survive Day & Night and burns out.
+		{name: "day and night blinker", rule: "B3678/S34678", start: []string{".o.", ".o.", ".o."}, generations: 1,
+			want: []string{"...", "o.o", "..."}},
+		{name: "day and night blinker dies", rule: "B3678/S34678", start: []string{".o.", ".o.", ".o."}, generations: 2,
+			want: []string{"...", "...", "..."}},
+
+		{name: "life without death", rule: "B3/S012345678", start: []string{"o"}, generations: 5},
+	}
+
+	const x0, y0 = 20, 20
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := mustParseRule(t, tt.rule)
+			board := pattern(x0, y0, tt.start...)
+			for range tt.generations {
+				board, _ = step(&board, rule, Bounded)
+			}
+
+			want := pattern(uint(x0+tt.dx), uint(y0+tt.dy), tt.start...)
+			if tt.want != nil {
+				want = pattern(uint(x0+tt.dx), uint(y0+tt.dy), tt.want...)
+			}
+			if board != want {
+				t.Errorf("after %v generations of %v got\n%v\nwant\n%v", tt.generations, tt.rule, render(&board), render(&want))
+			}
+		})
+	}
+}
+
+func TestStepCountsAlive(t *testing.T) {
+	board := pattern(10, 10, ".o.", "..o", "ooo")
+	_, alive := step(&board, Conway, Bounded)
+	if alive != 5 {
+		t.Errorf("glider has %v live cells after a step, want 5", alive)
+	}
+}
+
+// render draws the area around the patterns under test for failure messages.
+func render(board *[boardSizeX][boardSizeY]bool) string {
+	out := ""
+	for y := uint(16); y < 30; y++ {
+		for x := uint(16); x < 30; x++ {
+			if board[x][y] {
+				out += "o"
+			} else {
+				out += "."
+			}
+		}
+		out += "\n"
+	}
+	return out
+}
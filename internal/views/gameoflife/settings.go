@@ -0,0 +1,101 @@
+package gameoflife
+
+import (
+	"apparently-experiments/internal/shared"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+const URI_PARAM_RULE = "rule"
+const URI_PARAM_TOPOLOGY = "topology"
+
+// Settings are the board's rule in B/S notation and its topology's name. Empty fields leave the setting unchanged.
+type Settings struct {
+	Rule     string `json:"rule,omitempty"`
+	Topology string `json:"topology,omitempty"`
+}
+
+// Settings returns the board's current settings.
+func (gb *GameBoard) Settings() Settings {
+	gb.rw.RLock()
+	defer gb.rw.RUnlock()
+	return Settings{Rule: gb.rule.String(), Topology: gb.topology.String()}
+}
+
+// Apply changes every setting given in settings, or none of them if any is invalid.
+func (gb *GameBoard) Apply(settings Settings) error {
+	rule, topology := gb.Rule(), gb.Topology()
+	var err error
+	if settings.Rule != "" {
+		if rule, err = ParseRule(settings.Rule); err != nil {
+			return err
+		}
+	}
+	if settings.Topology != "" {
+		if topology, err = ParseTopology(settings.Topology); err != nil {
+			return err
+		}
+	}
+	gb.rw.Lock()
+	defer gb.rw.Unlock()
+	gb.rule, gb.topology = rule, topology
+	return nil
+}
+
+// changeSettings switches every replica's board to the rule and topology given in the request's parameters.
+// Datastar requests are answered over SSE and anything else, such as a script, with the new settings as JSON.
+func (h *room) changeSettings(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("game of life changeSettings()", "request_id", r.Header.Get(shared.RequestIDHeader))
+	settings, err := readSettings(r)
+	if r.Header.Get("Datastar-Request") != "true" {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.publish(r.Context(), replicaMessage{Settings: settings}); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeSettings(w, settings)
+		return
+	}
+
+	sse := datastar.NewSSE(w, r)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	if err := h.publish(r.Context(), replicaMessage{Settings: settings}); err != nil {
+		_ = sse.ConsoleError(err)
+	}
+}
+
+// readSettings validates the settings in the request's parameters and returns them in their canonical form.
+func readSettings(r *http.Request) (Settings, error) {
+	settings := Settings{}
+	if r.URL.Query().Has(URI_PARAM_RULE) {
+		rule, err := ParseRule(r.URL.Query().Get(URI_PARAM_RULE))
+		if err != nil {
+			return settings, err
+		}
+		settings.Rule = rule.String()
+	}
+	if r.URL.Query().Has(URI_PARAM_TOPOLOGY) {
+		topology, err := ParseTopology(r.URL.Query().Get(URI_PARAM_TOPOLOGY))
+		if err != nil {
+			return settings, err
+		}
+		settings.Topology = topology.String()
+	}
+	return settings, nil
+}
+
+func writeSettings(w http.ResponseWriter, settings Settings) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(settings); err != nil {
+		slog.Error("Failed to write game of life settings", "error", err)
+	}
+}
@@ -0,0 +1,151 @@
+package gameoflife
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strings"
+)
+
+const URI_PARAM_ENABLED = "enabled"
+
+const (
+	// The number of generations of statistics shown in the sparkline.
+	statsSize = 120
+	// Cycles up to this many generations long are detected. A glider takes 200 generations to cross a 50x50 torus.
+	maxPeriod = 256
+	// With auto reseed on, a stable board is left on show for this many generations before it is replaced.
+	reseedAfter     = 10
+	sparklineWidth  = 240
+	sparklineHeight = 40
+)
+
+// Stats describes a single generation.
+type Stats struct {
+	Generation uint64
+	Population int
+	Births     int
+	Deaths     int
+}
+
+// Stability reports whether the board has settled into a cycle, and since which generation.
+// A Period of 0 means it is still changing, and 1 that it is a still life.
+type Stability struct {
+	Period int
+	Since  uint64
+}
+
+func (s Stability) String() string {
+	switch s.Period {
+	case 0:
+		return "Still evolving"
+	case 1:
+		return fmt.Sprintf("Stabilized as a still life at generation %v", s.Since)
+	default:
+		return fmt.Sprintf("Stabilized at period %v since generation %v", s.Period, s.Since)
+	}
+}
+
+// stats keeps the statistics of recent generations and watches for the board repeating itself.
+// It is only touched by serve().
+type stats struct {
+	series []Stats
+	// hashes holds the hash of each of the last maxPeriod generations, indexed by generation.
+	hashes     [maxPeriod]uint64
+	known      int
+	generation uint64
+	hash       uint64
+	stability  Stability
+}
+
+func hashBoard(packed []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(packed)
+	return h.Sum64()
+}
+
+// observe takes in every frame published. A frame one generation on from the last is a step, which is added to the series
+// and checked against earlier generations. Anything else, such as an edit or a rewind, starts the cycle detection over.
+func (st *stats) observe(frame *Frame, packed []byte) {
+	hash := hashBoard(packed)
+	generation := frame.History.Current
+	if st.known > 0 && generation == st.generation && hash == st.hash {
+		return
+	}
+
+	stepped := st.known > 0 && generation == st.generation+1
+	current := Stats{Generation: generation, Population: frame.Board.Population()}
+	if stepped {
+		for _, change := range frame.Changes {
+			if change.Value {
+				current.Births++
+			} else {
+				current.Deaths++
+			}
+		}
+	} else {
+		st.known = 0
+		st.stability = Stability{}
+	}
+	// Drop what followed a rewound or edited generation, as it no longer happened.
+	for len(st.series) > 0 && st.series[len(st.series)-1].Generation >= generation {
+		st.series = st.series[:len(st.series)-1]
+	}
+	st.series = append(st.series, current)
+	if len(st.series) > statsSize {
+		st.series = st.series[len(st.series)-statsSize:]
+	}
+
+	if stepped && st.stability.Period == 0 {
+		for period := 1; period <= min(st.known, maxPeriod-1); period++ {
+			if st.hashes[(generation-uint64(period))%maxPeriod] == hash {
+				st.stability = Stability{Period: period, Since: generation - uint64(period)}
+				break
+			}
+		}
+	}
+	st.hashes[generation%maxPeriod] = hash
+	st.known = min(st.known+1, maxPeriod)
+	st.generation, st.hash = generation, hash
+}
+
+// Series copies the recent statistics, oldest first.
+func (st *stats) Series() []Stats {
+	return append([]Stats(nil), st.series...)
+}
+
+// reseedDue reports whether a stable board has been shown long enough to be replaced.
+func (st *stats) reseedDue() bool {
+	return st.stability.Period > 0 && st.generation-st.stability.Since >= reseedAfter
+}
+
+// reseed replaces a board that has stabilized with a random one. The seed comes from the board, so every replica
+// reseeds the same way. It must only be called from the serve() worker.
+func (h *room) reseed() *GameBoard {
+	seed := int64(h.stats.hash ^ h.generation)
+	slog.Info("Reseeding stable game of life board", "room", h.name, "stability", h.stats.stability.String())
+	h.board.SetBoard(randomBoard(seed, defaultDensity))
+	return h.broadcast()
+}
+
+// sparkline plots the population of each generation in series as SVG polyline points.
+func sparkline(series []Stats) string {
+	if len(series) == 0 {
+		return ""
+	}
+	peak := 1
+	for _, s := range series {
+		peak = max(peak, s.Population)
+	}
+	var points strings.Builder
+	for i, s := range series {
+		x := i * sparklineWidth / max(statsSize-1, 1)
+		y := sparklineHeight - s.Population*sparklineHeight/peak
+		fmt.Fprintf(&points, "%v,%v ", x, y)
+	}
+	return strings.TrimSpace(points.String())
+}
+
+func autoReseedExpression(basePath string) string {
+	return fmt.Sprintf("@post('%v?%v=%v&%v=' + evt.target.checked)", basePath, URI_PARAM_CONTROL, ActionAutoReseed, URI_PARAM_ENABLED)
+}
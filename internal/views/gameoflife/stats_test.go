@@ -0,0 +1,113 @@
+package gameoflife
+
+import "testing"
+
+// statsRun plays a board through stats the way a room's broadcast() does.
+type statsRun struct {
+	stats      stats
+	prev       *Frame
+	board      [boardSizeX][boardSizeY]bool
+	generation uint64
+}
+
+func newStatsRun(board [boardSizeX][boardSizeY]bool) *statsRun {
+	run := &statsRun{board: board}
+	run.show()
+	return run
+}
+
+func (run *statsRun) show() {
+	gb := NewGameBoard()
+	gb.SetBoard(run.board)
+	frame := nextFrame(run.prev, &gb, defaultControls(), History{Current: run.generation}, "")
+	run.stats.observe(frame, packBoard(&run.board))
+	run.prev = frame
+}
+
+func (run *statsRun) step(generations int) {
+	for range generations {
+		run.board, _ = step(&run.board, Conway, Torus)
+		run.generation++
+		run.show()
+	}
+}
+
+func TestStatsDetectsCycles(t *testing.T) {
+	tests := []struct {
+		name  string
+		start [boardSizeX][boardSizeY]bool
+		// steps is the number of generations played, and want the stability seen after each of them.
+		steps int
+		want  Stability
+	}{
+		{name: "block is a still life", start: pattern(20, 20, "oo", "oo"), steps: 1, want: Stability{Period: 1, Since: 0}},
+		{name: "blinker is still evolving after one step", start: pattern(20, 20, "ooo"), steps: 1, want: Stability{}},
+		{name: "blinker has period 2", start: pattern(20, 20, "ooo"), steps: 2, want: Stability{Period: 2, Since: 0}},
+		{name: "beacon has period 2", start: pattern(20, 20, "oo..", "oo..", "..oo", "..oo"), steps: 5, want: Stability{Period: 2, Since: 0}},
+		// It takes 4 generations to move a cell diagonally, and 50 of those to get all the way round the torus.
+		{name: "glider comes round the torus", start: pattern(20, 20, ".o.", "..o", "ooo"), steps: 200, want: Stability{Period: 200, Since: 0}},
+		{name: "glider is not yet round the torus", start: pattern(20, 20, ".o.", "..o", "ooo"), steps: 199, want: Stability{}},
+		// An empty board is a still life too.
+		{name: "dying pattern", start: pattern(20, 20, "o"), steps: 2, want: Stability{Period: 1, Since: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := newStatsRun(tt.start)
+			run.step(tt.steps)
+			if run.stats.stability != tt.want {
+				t.Errorf("after %v generations stability is %+v, want %+v", tt.steps, run.stats.stability, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsEditStartsOver(t *testing.T) {
+	run := newStatsRun(pattern(20, 20, "ooo"))
+	run.step(3)
+	if run.stats.stability.Period != 2 {
+		t.Fatalf("blinker stability is %+v, want period 2", run.stats.stability)
+	}
+
+	// Adding a block far from the blinker keeps the board cycling, but as a different board.
+	run.board[40][40], run.board[40][41], run.board[41][40], run.board[41][41] = true, true, true, true
+	run.show()
+	if run.stats.stability != (Stability{}) {
+		t.Fatalf("stability after an edit is %+v, want it still evolving", run.stats.stability)
+	}
+	run.step(1)
+	if run.stats.stability != (Stability{}) {
+		t.Fatalf("one step after an edit stability is %+v, want it still evolving", run.stats.stability)
+	}
+	run.step(1)
+	if want := (Stability{Period: 2, Since: 3}); run.stats.stability != want {
+		t.Errorf("two steps after an edit stability is %+v, want %+v", run.stats.stability, want)
+	}
+}
+
+func TestStatsSeries(t *testing.T) {
+	run := newStatsRun(pattern(20, 20, "ooo"))
+	run.step(statsSize + 10)
+
+	series := run.stats.Series()
+	if len(series) != statsSize {
+		t.Fatalf("series holds %v generations, want %v", len(series), statsSize)
+	}
+	for i, s := range series {
+		// Every step of a blinker turns two cells off and two on.
+		want := Stats{Generation: uint64(11 + i), Population: 3, Births: 2, Deaths: 2}
+		if s != want {
+			t.Fatalf("series[%v] = %+v, want %+v", i, s, want)
+		}
+	}
+
+	// Rewinding drops the generations that followed from the series.
+	run.generation = 100
+	run.show()
+	series = run.stats.Series()
+	if last := series[len(series)-1]; last.Generation != 100 || last.Births != 0 || last.Deaths != 0 {
+		t.Errorf("series ends with %+v after rewinding to generation 100", last)
+	}
+	if len(series) != 100-11+1 {
+		t.Errorf("series holds %v generations after rewinding, want %v", len(series), 100-11+1)
+	}
+}
@@ -0,0 +1,73 @@
+package gameoflife
+
+import "fmt"
+
+// Topology decides what lies beyond the edges of the board when counting neighbours.
+type Topology int
+
+const (
+	// Bounded treats everything past the edges as dead cells.
+	Bounded Topology = iota
+	// Torus wraps both axes, so whatever leaves one edge comes back in at the opposite one.
+	Torus
+	// KleinBottle wraps like a torus, except that crossing the top or bottom edge also mirrors left and right.
+	KleinBottle
+	// Cylinder wraps left and right but leaves the top and bottom edges bounded.
+	Cylinder
+)
+
+var topologyNames = map[Topology]string{
+	Bounded:     "bounded",
+	Torus:       "torus",
+	KleinBottle: "klein",
+	Cylinder:    "cylinder",
+}
+
+// topologies lists every topology in the order they are offered in the board settings.
+var topologies = []Topology{Bounded, Torus, KleinBottle, Cylinder}
+
+func (t Topology) String() string {
+	return topologyNames[t]
+}
+
+// Label is the topology's name as shown to players.
+func (t Topology) Label() string {
+	switch t {
+	case Torus:
+		return "Torus"
+	case KleinBottle:
+		return "Klein bottle"
+	case Cylinder:
+		return "Cylinder"
+	default:
+		return "Bounded"
+	}
+}
+
+func ParseTopology(s string) (Topology, error) {
+	for topology, name := range topologyNames {
+		if name == s {
+			return topology, nil
+		}
+	}
+	return Bounded, fmt.Errorf("unknown topology %q, expected one of bounded, torus, klein or cylinder", s)
+}
+
+// wrap maps a neighbour's coordinates, which may lie up to one cell past an edge, back onto the board.
+// It reports false when the neighbour is off the board and so counts as dead.
+func (t Topology) wrap(x, y int) (uint, uint, bool) {
+	const w, h = boardSizeX, boardSizeY
+	if t == KleinBottle && (y < 0 || y >= h) {
+		x = w - 1 - x
+	}
+	if t != Bounded {
+		x = (x + w) % w
+	}
+	if t == Torus || t == KleinBottle {
+		y = (y + h) % h
+	}
+	if x < 0 || x >= w || y < 0 || y >= h {
+		return 0, 0, false
+	}
+	return uint(x), uint(y), true
+}
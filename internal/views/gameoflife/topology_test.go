@@ -0,0 +1,177 @@
+package gameoflife
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// cells places the given live cells on an empty board.
+func cells(live ...[2]uint) [boardSizeX][boardSizeY]bool {
+	board := [boardSizeX][boardSizeY]bool{}
+	for _, c := range live {
+		board[c[0]][c[1]] = true
+	}
+	return board
+}
+
+// liveCells lists a board's live cells in column order, for failure messages.
+func liveCells(board *[boardSizeX][boardSizeY]bool) [][2]uint {
+	live := make([][2]uint, 0)
+	for x := range uint(boardSizeX) {
+		for y := range uint(boardSizeY) {
+			if board[x][y] {
+				live = append(live, [2]uint{x, y})
+			}
+		}
+	}
+	return live
+}
+
+// TestTopologyEdges steps patterns across the edges of the board, with every expected cell worked out by hand.
+func TestTopologyEdges(t *testing.T) {
+	// A glider heading down and right, drawn as .o. / ..o / ooo with its top left at (10, 46).
+	glider := [][2]uint{{11, 46}, {12, 47}, {10, 48}, {11, 48}, {12, 48}}
+	// A blinker lying along the top edge.
+	blinker := [][2]uint{{10, 0}, {11, 0}, {12, 0}}
+
+	tests := []struct {
+		name        string
+		topology    Topology
+		start       [][2]uint
+		generations int
+		want        [][2]uint
+	}{
+		{
+			// Straddling the corner, the glider wraps on both axes, and two cycles later it sits in the opposite corner.
+			name:     "torus glider through the corner",
+			topology: Torus,
+			start:    [][2]uint{{49, 48}, {0, 49}, {48, 0}, {49, 0}, {0, 0}},
+			// Moved (2, 2) to start at (50, 50), which is (0, 0).
+			generations: 8,
+			want:        [][2]uint{{1, 0}, {2, 1}, {0, 2}, {1, 2}, {2, 2}},
+		},
+		{
+			// After eight cycles the glider has moved (8, 8) and comes back in at the top, unchanged.
+			name:        "torus glider across the bottom edge",
+			topology:    Torus,
+			start:       glider,
+			generations: 32,
+			want:        [][2]uint{{19, 4}, {20, 5}, {18, 6}, {19, 6}, {20, 6}},
+		},
+		{
+			// The same glider comes back in at the top mirrored left to right, x becoming 49-x, so it now heads left.
+			name:        "klein bottle glider across the bottom edge",
+			topology:    KleinBottle,
+			start:       glider,
+			generations: 32,
+			want:        [][2]uint{{30, 4}, {29, 5}, {31, 6}, {30, 6}, {29, 6}},
+		},
+		{
+			// A cylinder wraps left and right, so a glider leaving the right edge comes back in on the left.
+			name:        "cylinder glider across the right edge",
+			topology:    Cylinder,
+			start:       [][2]uint{{47, 20}, {48, 21}, {46, 22}, {47, 22}, {48, 22}},
+			generations: 32,
+			want:        [][2]uint{{5, 28}, {6, 29}, {4, 30}, {5, 30}, {6, 30}},
+		},
+		{
+			// Standing up, the blinker would reach above the board, so only two of its cells are left.
+			name:        "bounded blinker clipped by the top edge",
+			topology:    Bounded,
+			start:       blinker,
+			generations: 1,
+			want:        [][2]uint{{11, 0}, {11, 1}},
+		},
+		{
+			// Two cells with one neighbour each both die.
+			name:        "bounded clipped blinker dies",
+			topology:    Bounded,
+			start:       blinker,
+			generations: 2,
+			want:        [][2]uint{},
+		},
+		{
+			name:        "torus blinker across the top edge",
+			topology:    Torus,
+			start:       blinker,
+			generations: 1,
+			want:        [][2]uint{{11, 0}, {11, 1}, {11, 49}},
+		},
+		{
+			// The cell above (11, 0) is at the bottom of the board, mirrored to 49-11.
+			name:        "klein bottle blinker across the top edge",
+			topology:    KleinBottle,
+			start:       blinker,
+			generations: 1,
+			want:        [][2]uint{{11, 0}, {11, 1}, {38, 49}},
+		},
+		{
+			name:        "klein bottle blinker keeps its period",
+			topology:    KleinBottle,
+			start:       blinker,
+			generations: 2,
+			want:        blinker,
+		},
+	}
+
+	steppers := map[string]func(*[boardSizeX][boardSizeY]bool, Rule, Topology) ([boardSizeX][boardSizeY]bool, int){
+		"packed": step,
+		"naive":  stepNaive,
+	}
+	for _, tt := range tests {
+		for name, stepper := range steppers {
+			t.Run(tt.name+" "+name, func(t *testing.T) {
+				board := cells(tt.start...)
+				for range tt.generations {
+					board, _ = stepper(&board, Conway, tt.topology)
+				}
+				want := cells(tt.want...)
+				if board != want {
+					t.Errorf("after %v generations on a %v board got %v, want %v", tt.generations, tt.topology, liveCells(&board), liveCells(&want))
+				}
+			})
+		}
+	}
+}
+
+// TestTopologyWrap checks where the neighbours of each corner land.
+func TestTopologyWrap(t *testing.T) {
+	tests := []struct {
+		topology Topology
+		x, y     int
+		want     string
+	}{
+		{Bounded, -1, 0, "off"},
+		{Bounded, 0, 50, "off"},
+		{Torus, -1, -1, "(49, 49)"},
+		{Torus, 50, 50, "(0, 0)"},
+		{KleinBottle, -1, 10, "(49, 10)"},
+		{KleinBottle, 0, -1, "(49, 49)"},
+		{KleinBottle, 3, 50, "(46, 0)"},
+		{KleinBottle, -1, 50, "(0, 0)"},
+		{Cylinder, 50, 7, "(0, 7)"},
+		{Cylinder, 7, -1, "off"},
+	}
+	for _, tt := range tests {
+		got := "off"
+		if x, y, ok := tt.topology.wrap(tt.x, tt.y); ok {
+			got = fmt.Sprintf("(%v, %v)", x, y)
+		}
+		if got != tt.want {
+			t.Errorf("%v wrap(%v, %v) = %v, want %v", tt.topology, tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+// TestTopologiesListed makes sure every topology can be chosen and parsed back.
+func TestTopologiesListed(t *testing.T) {
+	for topology := range topologyNames {
+		if !slices.Contains(topologies, topology) {
+			t.Errorf("%v is missing from the board settings", topology)
+		}
+		if parsed, err := ParseTopology(topology.String()); err != nil || parsed != topology {
+			t.Errorf("ParseTopology(%q) = %v, %v", topology.String(), parsed, err)
+		}
+	}
+}
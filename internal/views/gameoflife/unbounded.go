@@ -0,0 +1,314 @@
+package gameoflife
+
+import (
+	"apparently-experiments/internal/hub"
+	"apparently-experiments/internal/shared"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+const (
+	URI_PARAM_FIT   = "fit"
+	URI_PARAM_RESET = "reset"
+	// The on screen size of the universe, which listeners see more or less of as they zoom.
+	universeWidthPX  = 640
+	universeHeightPX = 480
+	// Zoom is the size of each cell in pixels.
+	minZoom     = 1
+	maxZoom     = 16
+	defaultZoom = 8
+	// Each live cell in view is its own element, so frames are capped to keep a zoomed out view of a busy area light.
+	maxRenderedCells = 4000
+	// The universe stops growing past this population until it is reset, to keep the demo within its memory budget.
+	maxPopulation = 100000
+	// The universe plays two generations a second, pausing for a couple of seconds after someone flips a cell.
+	universeTickMS = 500
+	universePause  = 4
+)
+
+// The Gosper glider gun fires a glider every 30 generations, so it keeps growing for as long as it runs.
+const gosperGliderGun = `x = 36, y = 9, rule = B3/S23
+24bo$22bobo$12b2o6b2o12b2o$11bo3bo4b2o12b2o$2o8bo5bo3b2o$2o8bo3bob2o4b
+obo$10bo5bo7bo$11bo3bo$12b2o!`
+
+// The R-pentomino takes over a thousand generations to settle, spreading well past the starting view.
+const rPentomino = `x = 3, y = 3, rule = B3/S23
+b2o$2o$bo!`
+
+// UniverseView is the part of the universe a listener has panned and zoomed to.
+type UniverseView struct {
+	X    int64 `json:"x"`
+	Y    int64 `json:"y"`
+	Zoom int64 `json:"zoom"`
+}
+
+func (v UniverseView) Width() int64 {
+	return universeWidthPX / v.Zoom
+}
+
+func (v UniverseView) Height() int64 {
+	return universeHeightPX / v.Zoom
+}
+
+type universeSignals struct {
+	Universe UniverseView `json:"universe"`
+}
+
+// readUniverseView reads the view a listener sends along with its requests, clamping the zoom to what is offered.
+func readUniverseView(r *http.Request) (UniverseView, error) {
+	signals := universeSignals{Universe: UniverseView{Zoom: defaultZoom}}
+	if err := datastar.ReadSignals(r, &signals); err != nil {
+		return UniverseView{Zoom: defaultZoom}, err
+	}
+	signals.Universe.Zoom = min(max(signals.Universe.Zoom, minZoom), maxZoom)
+	return signals.Universe, nil
+}
+
+// UniverseFrame is the part of the universe shown to one listener.
+type UniverseFrame struct {
+	View       UniverseView
+	Cells      []Point64
+	Truncated  bool
+	Population int
+	Generation uint64
+}
+
+// UniverseHandler serves a Life universe without edges. Unlike the board, it only lives on this replica.
+type UniverseHandler struct {
+	universe *Universe
+	tx       chan Point64
+	reset    chan struct{}
+	// hub carries the universe's version, bumped on every change, so listeners know to render their view again.
+	hub *hub.Hub[uint64]
+}
+
+func NewUniverseHandler() http.Handler {
+	h := &UniverseHandler{
+		tx:    make(chan Point64, channelBuffer),
+		reset: make(chan struct{}, 1),
+		hub:   hub.New[uint64]("gameoflife:unbounded", hub.Options{Policy: hub.Latest}),
+	}
+	universe, err := NewUniverse(Conway)
+	if err != nil {
+		panic(fmt.Sprintf("unbounded universe: %v", err))
+	}
+	h.universe = universe
+	h.seed()
+	go h.serve()
+	return h
+}
+
+// seed starts the universe over with patterns that keep growing.
+func (h *UniverseHandler) seed() {
+	h.universe.Clear()
+	for _, seed := range []struct {
+		rle  string
+		x, y int64
+	}{{gosperGliderGun, 2, 2}, {rPentomino, 50, 40}} {
+		pattern, err := ParseRLE(seed.rle)
+		if err != nil {
+			panic(fmt.Sprintf("unbounded universe seed: %v", err))
+		}
+		h.universe.Stamp(pattern, seed.x, seed.y)
+	}
+}
+
+func (h *UniverseHandler) serve() {
+	slog.Info("Unbounded Game Of Life worker started")
+	ticker := time.NewTicker(universeTickMS * time.Millisecond)
+	defer ticker.Stop()
+
+	var version uint64
+	var pause uint
+	full := false
+	for {
+		select {
+		case p := <-h.tx:
+			h.universe.Toggle(p.X, p.Y)
+			pause = universePause
+		case <-h.reset:
+			h.seed()
+			full = false
+		case <-ticker.C:
+			if pause > 0 {
+				pause--
+				continue
+			}
+			// Nobody can see the universe, so leave it where it is until they come back.
+			if h.hub.Count() == 0 {
+				continue
+			}
+			if population := h.universe.Population(); population > maxPopulation {
+				if !full {
+					slog.Warn("Unbounded universe reached its population limit and is paused", "population", population)
+					full = true
+				}
+				continue
+			}
+			h.universe.Step()
+		}
+		version++
+		h.hub.Publish(version)
+	}
+}
+
+func (h *UniverseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if r.URL.Query().Has(URI_PARAM_RESET) {
+			// A reset already waiting covers this one too, and the handler must not wait on the worker.
+			select {
+			case h.reset <- struct{}{}:
+			default:
+			}
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			h.toggle(w, r)
+		}
+
+	case http.MethodGet:
+		if r.URL.Query().Has("listen") {
+			h.listen(w, r)
+		} else if r.URL.Query().Has(URI_PARAM_FIT) {
+			h.fit(w, r)
+		} else {
+			templ.Handler(Unbounded(h.frame(UniverseView{Zoom: defaultZoom}))).ServeHTTP(w, r)
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *UniverseHandler) frame(view UniverseView) UniverseFrame {
+	cells, truncated := h.universe.Window(view.X, view.Y, view.Width(), view.Height(), maxRenderedCells)
+	return UniverseFrame{
+		View:       view,
+		Cells:      cells,
+		Truncated:  truncated,
+		Population: h.universe.Population(),
+		Generation: h.universe.Generation(),
+	}
+}
+
+func (h *UniverseHandler) sendFrame(sse *datastar.ServerSentEventGenerator, view UniverseView) error {
+	frame := h.frame(view)
+	if err := sse.PatchElementTempl(UniverseCells(frame)); err != nil {
+		return err
+	}
+	return sse.PatchElementTempl(UniverseStatus(frame))
+}
+
+// listen streams the view the listener has panned and zoomed to. Moving the view opens a new stream.
+func (h *UniverseHandler) listen(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Header.Get(shared.RequestIDHeader)
+	view, err := readUniverseView(r)
+	sse := datastar.NewSSE(w, r)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	slog.Debug("unbounded universe listen()", "request_id", requestId, "view", view)
+
+	listener := h.hub.Subscribe(sse.Context())
+	if err := h.sendFrame(sse, view); err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	for {
+		select {
+		case <-sse.Context().Done():
+			slog.Debug("unbounded universe listener disconnected", "request_id", requestId)
+			return
+		case _, ok := <-listener.C:
+			if !ok {
+				return
+			}
+			if err := h.sendFrame(sse, view); err != nil {
+				slog.Error("Error occurred when patching", "error", err)
+			}
+		}
+	}
+}
+
+// fit moves the listener's view to the middle of the population at its current zoom.
+func (h *UniverseHandler) fit(w http.ResponseWriter, r *http.Request) {
+	view, err := readUniverseView(r)
+	sse := datastar.NewSSE(w, r)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	low, high, ok := h.universe.Bounds()
+	if !ok {
+		low, high = Point64{}, Point64{}
+	}
+	view.X = (low.X+high.X)/2 - view.Width()/2
+	view.Y = (low.Y+high.Y)/2 - view.Height()/2
+	if err := sse.MarshalAndPatchSignals(universeSignals{Universe: view}); err != nil {
+		_ = sse.ConsoleError(err)
+	}
+}
+
+func (h *UniverseHandler) toggle(w http.ResponseWriter, r *http.Request) {
+	sse := datastar.NewSSE(w, r)
+	x, err := strconv.ParseInt(r.URL.Query().Get(URI_PARAM_X), 10, 64)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	y, err := strconv.ParseInt(r.URL.Query().Get(URI_PARAM_Y), 10, 64)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	if x < -maxCoordinate || x > maxCoordinate || y < -maxCoordinate || y > maxCoordinate {
+		_ = sse.ConsoleError(fmt.Errorf("Cell position (%v, %v) is out of bounds", x, y))
+		return
+	}
+	// Like a reset, the handler must not wait on a worker that has fallen behind, so a full queue turns the cell away.
+	select {
+	case h.tx <- Point64{X: x, Y: y}:
+	case <-r.Context().Done():
+	default:
+		_ = sse.ConsoleError(fmt.Errorf("the universe is busy, please try again"))
+	}
+}
+
+func universeCellStyle(view UniverseView, cell Point64) string {
+	return fmt.Sprintf("left: %vpx; top: %vpx; width: %vpx; height: %vpx;",
+		(cell.X-view.X)*view.Zoom, (cell.Y-view.Y)*view.Zoom, view.Zoom, view.Zoom)
+}
+
+func universeStyle() string {
+	return fmt.Sprintf("width: %vpx; height: %vpx;", universeWidthPX, universeHeightPX)
+}
+
+// panExpression moves the view by a quarter of its size in the given direction.
+func panExpression(dx, dy int) string {
+	return fmt.Sprintf("$universe.x += %v * Math.floor(%v / $universe.zoom); $universe.y += %v * Math.floor(%v / $universe.zoom)",
+		dx, universeWidthPX/4, dy, universeHeightPX/4)
+}
+
+// zoomInExpression doubles the cell size, keeping the middle of the view where it is.
+var zoomInExpression = fmt.Sprintf(
+	"if ($universe.zoom < %v) { $universe.x += Math.floor(%v / $universe.zoom); $universe.y += Math.floor(%v / $universe.zoom); $universe.zoom *= 2 }",
+	maxZoom, universeWidthPX/4, universeHeightPX/4)
+
+// zoomOutExpression halves the cell size, keeping the middle of the view where it is.
+var zoomOutExpression = fmt.Sprintf(
+	"if ($universe.zoom > %v) { $universe.x -= Math.floor(%v / $universe.zoom); $universe.y -= Math.floor(%v / $universe.zoom); $universe.zoom /= 2 }",
+	minZoom, universeWidthPX/2, universeHeightPX/2)
+
+// toggleExpression posts the cell under the pointer in universe coordinates.
+const toggleExpression = "@post('/gameoflife/unbounded?x=' + ($universe.x + Math.floor((evt.clientX - el.getBoundingClientRect().left) / $universe.zoom)) + " +
+	"'&y=' + ($universe.y + Math.floor((evt.clientY - el.getBoundingClientRect().top) / $universe.zoom)))"
+
+// The listen stream is opened by an effect on the view signals, so every pan and zoom replaces it with the new view.
+const universeListenExpression = "$universe.x; $universe.y; $universe.zoom; @get('/gameoflife/unbounded?listen', {openWhenHidden: true})"
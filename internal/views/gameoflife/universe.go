@@ -0,0 +1,262 @@
+package gameoflife
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+const (
+	// The universe is stored in square chunks of chunkSize cells, one uint64 per row, and only chunks with
+	// live cells in them are kept, so memory follows the population rather than the area it covers.
+	chunkBits = 6
+	chunkSize = 1 << chunkBits
+	chunkMask = chunkSize - 1
+	// Cells that wander further than this from the origin, such as gliders escaping a gun, are dropped
+	// so coordinates can never overflow.
+	maxCoordinate = 1 << 40
+)
+
+// Point64 is a cell in the unbounded universe, whose coordinates may be negative.
+type Point64 struct {
+	X int64
+	Y int64
+}
+
+type chunkKey struct {
+	X int64
+	Y int64
+}
+
+type chunk [chunkSize]uint64
+
+func keyOf(x, y int64) chunkKey {
+	// Arithmetic shifts round towards negative infinity, so negative coordinates land in the right chunk.
+	return chunkKey{X: x >> chunkBits, Y: y >> chunkBits}
+}
+
+// Universe is an unbounded Life universe that only stores its live cells.
+type Universe struct {
+	rw         sync.RWMutex
+	chunks     map[chunkKey]*chunk
+	population int
+	generation uint64
+	rule       Rule
+}
+
+func NewUniverse(rule Rule) (*Universe, error) {
+	if rule.Birth&1 != 0 {
+		return nil, fmt.Errorf("rule %v gives birth to cells with no neighbours, which would fill an unbounded universe", rule)
+	}
+	return &Universe{chunks: make(map[chunkKey]*chunk), rule: rule}, nil
+}
+
+// get must be called with u.rw held.
+func (u *Universe) get(x, y int64) bool {
+	c, ok := u.chunks[keyOf(x, y)]
+	return ok && c[y&chunkMask]&(1<<(x&chunkMask)) != 0
+}
+
+// set must be called with u.rw held for writing.
+func (u *Universe) set(x, y int64, alive bool) {
+	if x < -maxCoordinate || x > maxCoordinate || y < -maxCoordinate || y > maxCoordinate {
+		return
+	}
+	key := keyOf(x, y)
+	c, ok := u.chunks[key]
+	if !ok {
+		if !alive {
+			return
+		}
+		c = &chunk{}
+		u.chunks[key] = c
+	}
+	row, bit := &c[y&chunkMask], uint64(1)<<(x&chunkMask)
+	was := *row&bit != 0
+	switch {
+	case alive && !was:
+		*row |= bit
+		u.population++
+	case !alive && was:
+		*row &^= bit
+		u.population--
+		if *c == (chunk{}) {
+			delete(u.chunks, key)
+		}
+	}
+}
+
+func (u *Universe) Get(x, y int64) bool {
+	u.rw.RLock()
+	defer u.rw.RUnlock()
+	return u.get(x, y)
+}
+
+func (u *Universe) Set(x, y int64, alive bool) {
+	u.rw.Lock()
+	defer u.rw.Unlock()
+	u.set(x, y, alive)
+}
+
+// Toggle flips a cell and returns its new state.
+func (u *Universe) Toggle(x, y int64) bool {
+	u.rw.Lock()
+	defer u.rw.Unlock()
+	alive := !u.get(x, y)
+	u.set(x, y, alive)
+	return alive
+}
+
+// Clear kills every cell and starts counting generations again.
+func (u *Universe) Clear() {
+	u.rw.Lock()
+	defer u.rw.Unlock()
+	u.chunks = make(map[chunkKey]*chunk)
+	u.population = 0
+	u.generation = 0
+}
+
+// Stamp draws the live cells of a pattern with its top left at (x0, y0).
+func (u *Universe) Stamp(p Pattern, x0, y0 int64) {
+	u.rw.Lock()
+	defer u.rw.Unlock()
+	for _, cell := range p.Cells {
+		u.set(x0+int64(cell.X), y0+int64(cell.Y), true)
+	}
+}
+
+func (u *Universe) Population() int {
+	u.rw.RLock()
+	defer u.rw.RUnlock()
+	return u.population
+}
+
+func (u *Universe) Generation() uint64 {
+	u.rw.RLock()
+	defer u.rw.RUnlock()
+	return u.generation
+}
+
+// each calls fn for every live cell, and must be called with u.rw held.
+func (u *Universe) each(fn func(x, y int64)) {
+	for key, c := range u.chunks {
+		for dy, row := range c {
+			for row != 0 {
+				dx := bits.TrailingZeros64(row)
+				row &= row - 1
+				fn(key.X<<chunkBits+int64(dx), key.Y<<chunkBits+int64(dy))
+			}
+		}
+	}
+}
+
+// Step advances the universe by one generation. Only the live cells and their neighbours are visited,
+// so the cost follows the population however far it has spread.
+func (u *Universe) Step() {
+	u.rw.Lock()
+	defer u.rw.Unlock()
+
+	counts := make(map[Point64]uint8, u.population*4)
+	u.each(func(x, y int64) {
+		for dy := int64(-1); dy <= 1; dy++ {
+			for dx := int64(-1); dx <= 1; dx++ {
+				if dx != 0 || dy != 0 {
+					counts[Point64{X: x + dx, Y: y + dy}]++
+				}
+			}
+		}
+	})
+	// Isolated live cells have no entry of their own, but may still survive under rules such as S0.
+	u.each(func(x, y int64) {
+		if _, ok := counts[Point64{X: x, Y: y}]; !ok {
+			counts[Point64{X: x, Y: y}] = 0
+		}
+	})
+
+	next := &Universe{chunks: make(map[chunkKey]*chunk, len(u.chunks)), rule: u.rule}
+	for p, n := range counts {
+		if u.rule.Next(u.get(p.X, p.Y), int(n)) {
+			next.set(p.X, p.Y, true)
+		}
+	}
+	u.chunks, u.population = next.chunks, next.population
+	u.generation++
+}
+
+// Window returns up to limit live cells inside the rectangle with its top left at (x, y),
+// and whether any were left out.
+func (u *Universe) Window(x, y, width, height int64, limit int) ([]Point64, bool) {
+	u.rw.RLock()
+	defer u.rw.RUnlock()
+
+	cells := make([]Point64, 0)
+	visit := func(key chunkKey, c *chunk) bool {
+		for dy, row := range c {
+			cy := key.Y<<chunkBits + int64(dy)
+			if cy < y || cy >= y+height {
+				continue
+			}
+			for row != 0 {
+				dx := bits.TrailingZeros64(row)
+				row &= row - 1
+				cx := key.X<<chunkBits + int64(dx)
+				if cx < x || cx >= x+width {
+					continue
+				}
+				if len(cells) == limit {
+					return false
+				}
+				cells = append(cells, Point64{X: cx, Y: cy})
+			}
+		}
+		return true
+	}
+
+	// Look up the chunks under the window directly unless there are more of them than there are stored chunks.
+	first, last := keyOf(x, y), keyOf(x+width-1, y+height-1)
+	if (last.X-first.X+1)*(last.Y-first.Y+1) > int64(len(u.chunks)) {
+		for key, c := range u.chunks {
+			if key.X >= first.X && key.X <= last.X && key.Y >= first.Y && key.Y <= last.Y && !visit(key, c) {
+				return cells, true
+			}
+		}
+		return cells, false
+	}
+	for cy := first.Y; cy <= last.Y; cy++ {
+		for cx := first.X; cx <= last.X; cx++ {
+			if c, ok := u.chunks[chunkKey{X: cx, Y: cy}]; ok && !visit(chunkKey{X: cx, Y: cy}, c) {
+				return cells, true
+			}
+		}
+	}
+	return cells, false
+}
+
+// Bounds returns the smallest rectangle holding every live cell, or false if there are none.
+func (u *Universe) Bounds() (min, max Point64, ok bool) {
+	u.rw.RLock()
+	defer u.rw.RUnlock()
+	u.each(func(x, y int64) {
+		if !ok {
+			min, max, ok = Point64{X: x, Y: y}, Point64{X: x, Y: y}, true
+			return
+		}
+		min.X, min.Y = minInt64(min.X, x), minInt64(min.Y, y)
+		max.X, max.Y = maxInt64(max.X, x), maxInt64(max.Y, y)
+	})
+	return min, max, ok
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,213 @@
+package presence
+
+import (
+	"apparently-experiments/internal/hub"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+var activeMembers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "presenceActiveMembers",
+	Help: "The number of people currently watching each demo",
+}, []string{"demo"})
+
+// A browser reconnecting its listen stream, e.g. after scrolling the checkboxes, is briefly disconnected.
+// Members are kept for this long after their last connection closes so the count does not flicker.
+const leaveGrace = 2 * time.Second
+
+const URI_PARAM_CURSOR string = "cursor"
+
+// Cursor is where a member's pointer is over the grid, measured in cells from its top left.
+type Cursor struct {
+	X     float64
+	Y     float64
+	Color string
+	// session is kept out of the rendered cursor so members stay anonymous.
+	session string
+}
+
+// State is what every listener is shown about who else is here.
+type State struct {
+	Count   int
+	Cursors []Cursor
+}
+
+// Others returns every cursor except the one belonging to session.
+func (s State) Others(session string) []Cursor {
+	others := make([]Cursor, 0, len(s.Cursors))
+	for _, cursor := range s.Cursors {
+		if cursor.session != session {
+			others = append(others, cursor)
+		}
+	}
+	return others
+}
+
+type member struct {
+	conns  int
+	cursor *Cursor
+	// left is bumped every time the member's last connection closes, so a stale grace timer can tell it was superseded.
+	left int
+}
+
+// Tracker counts the people watching one demo or room and shares where their cursors are.
+// Members are identified by their anonymous session, so several tabs from one browser count once.
+type Tracker struct {
+	name    string
+	mu      sync.Mutex
+	members map[string]*member
+	hub     *hub.Hub[State]
+	// closed is set by Close, after which nothing more is published.
+	closed bool
+}
+
+func New(name string) *Tracker {
+	return &Tracker{
+		name:    name,
+		members: make(map[string]*member),
+		hub:     hub.New[State]("presence:"+name, hub.Options{Policy: hub.Latest}),
+	}
+}
+
+// Join registers a listener for session until ctx is done and returns its subscription to presence updates.
+// It is called from each demo's listen() so that presence follows the lifetime of its stream.
+func (t *Tracker) Join(ctx context.Context, session string) *hub.Subscription[State] {
+	sub := t.hub.Subscribe(ctx)
+
+	t.mu.Lock()
+	m, ok := t.members[session]
+	if !ok {
+		m = &member{}
+		t.members[session] = m
+	}
+	m.conns++
+	t.publish()
+	t.mu.Unlock()
+
+	context.AfterFunc(ctx, func() { t.leave(session) })
+	return sub
+}
+
+func (t *Tracker) leave(session string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.members[session]
+	if !ok {
+		return
+	}
+	m.conns--
+	if m.conns > 0 {
+		return
+	}
+	m.left++
+	left := m.left
+	time.AfterFunc(leaveGrace, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if m.conns == 0 && m.left == left && t.members[session] == m {
+			delete(t.members, session)
+			t.publish()
+		}
+	})
+}
+
+// Move records where session's cursor is. Only members with an open listener are tracked.
+func (t *Tracker) Move(session string, x, y float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.members[session]
+	if !ok {
+		return
+	}
+	m.cursor = &Cursor{X: x, Y: y, Color: color(session), session: session}
+	t.publish()
+}
+
+// Count returns the number of people here.
+func (t *Tracker) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.members)
+}
+
+// Close stops the tracker once its room has closed, deleting the room's metrics along with it.
+func (t *Tracker) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	activeMembers.DeleteLabelValues(t.name)
+	t.hub.Close()
+}
+
+// publish must be called with t.mu held.
+func (t *Tracker) publish() {
+	if t.closed {
+		return
+	}
+	state := State{Count: len(t.members), Cursors: make([]Cursor, 0, len(t.members))}
+	for _, m := range t.members {
+		if m.cursor != nil {
+			state.Cursors = append(state.Cursors, *m.cursor)
+		}
+	}
+	activeMembers.WithLabelValues(t.name).Set(float64(state.Count))
+	t.hub.Publish(state)
+}
+
+type cursorSignals struct {
+	Cursor struct {
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+	} `json:"cursor"`
+}
+
+// ServeCursor records the cursor position posted by CursorExpression.
+func (t *Tracker) ServeCursor(w http.ResponseWriter, r *http.Request, session string) {
+	signals := cursorSignals{}
+	err := datastar.ReadSignals(r, &signals)
+	sse := datastar.NewSSE(w, r)
+	if err != nil {
+		_ = sse.ConsoleError(err)
+		return
+	}
+	t.Move(session, signals.Cursor.X, signals.Cursor.Y)
+}
+
+// Patch sends the badge and the other members' cursors to a listener.
+func Patch(sse *datastar.ServerSentEventGenerator, state State, session string, cellSize int) error {
+	if err := sse.PatchElementTempl(Badge(state.Count)); err != nil {
+		return err
+	}
+	return sse.PatchElementTempl(Cursors(state.Others(session), cellSize))
+}
+
+// CursorExpression posts the pointer's position over el, in cells, while the cursors toggle is on.
+// It must not be attached to the element that opened the listen stream, as datastar aborts an element's
+// previous request when it sends a new one.
+func CursorExpression(path string, cellSize int) string {
+	return fmt.Sprintf(
+		"if ($cursors) { $cursor = {x: (evt.clientX - el.getBoundingClientRect().left) / %v, "+
+			"y: (evt.clientY - el.getBoundingClientRect().top) / %v}; @post('%v') }",
+		cellSize, cellSize, path,
+	)
+}
+
+// color picks a stable hue for session so each member keeps the same cursor colour.
+func color(session string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(session))
+	return fmt.Sprintf("hsl(%v 80%% 60%%)", h.Sum32()%360)
+}
+
+func cursorStyle(cursor Cursor, cellSize int) string {
+	return fmt.Sprintf("left: %.1fpx; top: %.1fpx; background-color: %v;",
+		cursor.X*float64(cellSize), cursor.Y*float64(cellSize), cursor.Color)
+}